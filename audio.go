@@ -0,0 +1,140 @@
+package main
+
+import (
+	"dmgo/gameboy"
+	"encoding/binary"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+)
+
+// How much audio we try to keep queued, in stereo sample values, about 60ms
+const audioTarget = gameboy.SAMPLE_RATE * 2 * 60 / 1000
+
+// Anything queued past this is dropped, e.g. after the window was dragged
+const audioMax = audioTarget * 4
+
+// Most the emulation speed is nudged up or down to keep the queue at the target
+const audioMaxAdjust = 0.01
+
+// Silence played when the queue runs dry, in stereo sample values
+const audioSilence = 256
+
+// Samples from the emulator are queued here, and read by the ebiten player
+// The player reads from its own goroutine, hence the lock
+type audioStream struct {
+	lock    sync.Mutex
+	samples []int16
+}
+
+// Start playing the emulator sound, returns nil if there's no audio device
+func newAudioPlayer(stream *audioStream) *audio.Player {
+	ctx := audio.NewContext(gameboy.SAMPLE_RATE)
+
+	player, err := ctx.NewPlayer(stream)
+	if err != nil {
+		log.Println("Unable to start audio:", err)
+		return nil
+	}
+
+	player.SetBufferSize(50 * time.Millisecond)
+	player.Play()
+
+	return player
+}
+
+// Read is called by the player, converting queued samples into 16-bit little endian bytes
+func (s *audioStream) Read(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Only ever return whole stereo samples, 4 bytes each
+	count := min(len(p)/4*2, len(s.samples))
+
+	if count == 0 {
+		// Starved, play a little silence rather than blocking the player
+		count = min(len(p)/4*2, audioSilence)
+		clear(p[:count*2])
+		return count * 2, nil
+	}
+
+	for i, v := range s.samples[:count] {
+		binary.LittleEndian.PutUint16(p[i*2:], uint16(v))
+	}
+	s.samples = append(s.samples[:0], s.samples[count:]...)
+
+	return count * 2, nil
+}
+
+// Take all the audio generated by the emulator so far
+func readAudio(gb *gameboy.Gameboy) []int16 {
+	buf := make([]int16, gb.AudioBuffered())
+	n := gb.ReadAudio(buf)
+
+	return buf[:n]
+}
+
+// Queue audio for the player
+func (s *audioStream) fill(samples []int16) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.samples = append(s.samples, samples...)
+	if len(s.samples) > audioMax {
+		s.samples = append(s.samples[:0], s.samples[len(s.samples)-audioTarget:]...)
+	}
+}
+
+// Returns a factor to scale the emulator speed by, running slightly faster when the
+// queue is low and slower when it's high. This stops the sound crackling or drifting
+func (s *audioStream) rateAdjust() float64 {
+	s.lock.Lock()
+	queued := len(s.samples)
+	s.lock.Unlock()
+
+	diff := float64(audioTarget-queued) / audioTarget
+	return 1 + max(-audioMaxAdjust, min(audioMaxAdjust, diff*audioMaxAdjust))
+}
+
+// Sound being recorded to a .wav file
+type recording struct {
+	file *os.File
+	wav  *gameboy.WAVWriter
+}
+
+func startRecording(path string) (*recording, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	wav, err := gameboy.NewWAVWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	log.Printf("Recording audio to %s\n", path)
+	return &recording{file: file, wav: wav}, nil
+}
+
+func (r *recording) write(samples []int16) {
+	if err := r.wav.Write(samples); err != nil {
+		log.Println(err)
+	}
+}
+
+// Finish the .wav file, it's not playable until this is done
+func (r *recording) stop() {
+	if err := r.wav.Close(); err != nil {
+		log.Println(err)
+	}
+	if err := r.file.Close(); err != nil {
+		log.Println(err)
+	}
+
+	log.Printf("Stopped recording audio to %s\n", r.file.Name())
+}
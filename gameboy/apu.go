@@ -0,0 +1,729 @@
+package gameboy
+
+import "math"
+
+// Sound registers
+const NR10 = 0xFF10
+const NR11 = 0xFF11
+const NR12 = 0xFF12
+const NR13 = 0xFF13
+const NR14 = 0xFF14
+const NR21 = 0xFF16
+const NR22 = 0xFF17
+const NR23 = 0xFF18
+const NR24 = 0xFF19
+const NR30 = 0xFF1A
+const NR31 = 0xFF1B
+const NR32 = 0xFF1C
+const NR33 = 0xFF1D
+const NR34 = 0xFF1E
+const NR41 = 0xFF20
+const NR42 = 0xFF21
+const NR43 = 0xFF22
+const NR44 = 0xFF23
+const NR50 = 0xFF24
+const NR51 = 0xFF25
+const NR52 = 0xFF26
+const WAVE_RAM = 0xFF30
+const SOUND_END = 0xFF40
+
+// Audio samples are generated at this rate, in stereo
+const SAMPLE_RATE = 48000
+
+// Most samples held before the oldest are dropped, if nothing is reading them
+const MAX_BUFFERED_SAMPLES = SAMPLE_RATE * 2
+
+// Number of recent samples kept for each channel, for drawing a scope
+const SCOPE_SIZE = 1024
+
+// Bits which always read back as 1 for each register 0xFF10-0xFF2F, the rest are write only
+var soundReadMasks = [0x20]byte{
+	0x80, 0x3F, 0x00, 0xFF, 0xBF, // NR10-NR14
+	0xFF, 0x3F, 0x00, 0xFF, 0xBF, // NR20-NR24
+	0x7F, 0xFF, 0x9F, 0xFF, 0xBF, // NR30-NR34
+	0xFF, 0xFF, 0x00, 0x00, 0xBF, // NR40-NR44
+	0x00, 0x00, 0x70, // NR50-NR52
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // Unused
+}
+
+// Waveforms for the square channels, one bit per step for each duty cycle
+var dutyPatterns = [4]byte{
+	0b00000001, // 12.5%
+	0b10000001, // 25%
+	0b10000111, // 50%
+	0b01111110, // 75%
+}
+
+// Audio Processing Unit, with the two square wave channels, wave channel & noise channel
+// https://gbdev.io/pandocs/Audio.html
+type APU struct {
+	// Raw sound registers 0xFF10-0xFF2F, the channels read their settings from here
+	regs    [0x20]byte
+	waveRAM [16]byte
+
+	power     bool
+	frameStep byte // Next step of the frame sequencer, 0-7
+
+	ch1 squareChannel
+	ch2 squareChannel
+	ch3 waveChannel
+	ch4 noiseChannel
+
+	// Generated samples, interleaved left & right
+	samples       []int16
+	sampleCounter int
+
+	// High pass filter state for each side, this removes the DC offset of the DACs
+	capLeft  float64
+	capRight float64
+
+	// Channels can be left out of the mix, for debugging music
+	muted [4]bool
+
+	// Output of each channel before mixing, as ring buffers of the last SCOPE_SIZE samples
+	scopes   [4][SCOPE_SIZE]float32
+	scopePos int
+}
+
+// Length counter, shared by all channels, switches the channel off when it reaches zero
+type lengthCounter struct {
+	counter int
+	enabled bool
+	max     int
+}
+
+// Volume envelope, used by the square and noise channels
+type envelope struct {
+	volume byte
+	timer  int
+}
+
+type squareChannel struct {
+	regs    []byte // NRx0-NRx4
+	enabled bool
+	length  lengthCounter
+	env     envelope
+
+	dutyStep byte
+	timer    int
+
+	// Frequency sweep, only used by channel 1
+	sweepTimer   int
+	sweepEnabled bool
+	sweepShadow  int
+	sweepNegUsed bool // Set once a sweep calculation used negate mode
+}
+
+type waveChannel struct {
+	regs    []byte // NR30-NR34
+	enabled bool
+	length  lengthCounter
+
+	position byte // Which of the 32 samples in wave RAM is playing
+	timer    int  // Ticks until the next sample is read, the channel runs at half the clock speed
+	justRead bool // Set when wave RAM was read on the last tick, see waveAccess
+}
+
+type noiseChannel struct {
+	regs    []byte // NR40-NR44
+	enabled bool
+	length  lengthCounter
+	env     envelope
+
+	lfsr  uint16 // Linear feedback shift register, generates the noise
+	timer int
+}
+
+func NewAPU() *APU {
+	apu := &APU{
+		samples: make([]int16, 0, MAX_BUFFERED_SAMPLES),
+	}
+
+	apu.ch1.regs = apu.regs[0:5]
+	apu.ch2.regs = apu.regs[5:10]
+	apu.ch3.regs = apu.regs[10:15]
+	apu.ch4.regs = apu.regs[15:20]
+
+	apu.ch1.length.max = 64
+	apu.ch2.length.max = 64
+	apu.ch3.length.max = 256
+	apu.ch4.length.max = 64
+
+	return apu
+}
+
+func (apu *APU) read(addr uint16) byte {
+	if addr >= WAVE_RAM {
+		i, ok := apu.waveAccess(addr)
+		if !ok {
+			return 0xFF
+		}
+		return apu.waveRAM[i]
+	}
+
+	if addr == NR52 {
+		status := byte(0x70)
+		if apu.power {
+			status = bitSet(status, 7)
+		}
+		for i, on := range []bool{apu.ch1.enabled, apu.ch2.enabled, apu.ch3.enabled, apu.ch4.enabled} {
+			if on {
+				status = bitSet(status, uint(i))
+			}
+		}
+		return status
+	}
+
+	return apu.regs[addr-NR10] | soundReadMasks[addr-NR10]
+}
+
+func (apu *APU) write(addr uint16, data byte) {
+	// Wave RAM is accessible even with the power off
+	if addr >= WAVE_RAM {
+		if i, ok := apu.waveAccess(addr); ok {
+			apu.waveRAM[i] = data
+		}
+		return
+	}
+
+	if addr == NR52 {
+		apu.setPower(checkBit(data, 7))
+		return
+	}
+
+	// With the power off registers can't be written, apart from the length counters on DMG
+	if !apu.power {
+		switch addr {
+		case NR11:
+			apu.ch1.length.load(data & 0x3F)
+		case NR21:
+			apu.ch2.length.load(data & 0x3F)
+		case NR31:
+			apu.ch3.length.load(data)
+		case NR41:
+			apu.ch4.length.load(data & 0x3F)
+		}
+		return
+	}
+
+	old := apu.regs[addr-NR10]
+	apu.regs[addr-NR10] = data
+
+	// When the next frame sequencer step doesn't clock the length counters
+	// enabling a length counter clocks it once straight away
+	extraClock := apu.frameStep&1 == 1
+
+	switch addr {
+	case NR10:
+		// Clearing negate mode after it's been used in a calculation disables the channel
+		if checkBit(old, 3) && !checkBit(data, 3) && apu.ch1.sweepNegUsed {
+			apu.ch1.enabled = false
+		}
+	case NR11:
+		apu.ch1.length.load(data & 0x3F)
+	case NR12:
+		apu.ch1.enabled = apu.ch1.enabled && apu.ch1.dacEnabled()
+	case NR14:
+		apu.ch1.writeControl(data, extraClock, true)
+
+	case NR21:
+		apu.ch2.length.load(data & 0x3F)
+	case NR22:
+		apu.ch2.enabled = apu.ch2.enabled && apu.ch2.dacEnabled()
+	case NR24:
+		apu.ch2.writeControl(data, extraClock, false)
+
+	case NR30:
+		apu.ch3.enabled = apu.ch3.enabled && apu.ch3.dacEnabled()
+	case NR31:
+		apu.ch3.length.load(data)
+	case NR34:
+		apu.ch3.writeControl(data, extraClock, apu.waveRAM[:])
+
+	case NR41:
+		apu.ch4.length.load(data & 0x3F)
+	case NR42:
+		apu.ch4.enabled = apu.ch4.enabled && apu.ch4.dacEnabled()
+	case NR44:
+		apu.ch4.writeControl(data, extraClock)
+	}
+}
+
+// While channel 3 is playing the CPU can only reach the byte of wave RAM the channel is
+// reading, and on DMG only on the cycle it's read. Returns the byte that's accessed, or
+// false when the access is lost, then reads return 0xFF and writes are ignored
+func (apu *APU) waveAccess(addr uint16) (int, bool) {
+	if !apu.ch3.enabled {
+		return int(addr - WAVE_RAM), true
+	}
+
+	return int(apu.ch3.position / 2), apu.ch3.justRead
+}
+
+// Switching the power off clears all the registers, switching it on resets the frame sequencer
+func (apu *APU) setPower(on bool) {
+	if on && !apu.power {
+		apu.frameStep = 0
+		apu.ch1.dutyStep = 0
+		apu.ch2.dutyStep = 0
+		apu.ch3.position = 0
+	}
+
+	if !on && apu.power {
+		for i := NR10; i < NR52; i++ {
+			apu.regs[i-NR10] = 0
+		}
+
+		apu.ch1.enabled = false
+		apu.ch2.enabled = false
+		apu.ch3.enabled = false
+		apu.ch4.enabled = false
+
+		// The length counters keep their values, but NRx4 is cleared so they stop counting
+		apu.ch1.length.enabled = false
+		apu.ch2.length.enabled = false
+		apu.ch3.length.enabled = false
+		apu.ch4.length.enabled = false
+	}
+
+	apu.power = on
+}
+
+// Called on the falling edge of bit 4 of DIV, which happens at 512Hz
+// Step 0, 2, 4 & 6 clock the length counters, 2 & 6 the sweep and 7 the envelopes
+func (apu *APU) clockFrameSequencer() {
+	if !apu.power {
+		return
+	}
+
+	step := apu.frameStep
+	apu.frameStep = (apu.frameStep + 1) & 7
+
+	if step&1 == 0 {
+		apu.ch1.enabled = apu.ch1.length.clock() && apu.ch1.enabled
+		apu.ch2.enabled = apu.ch2.length.clock() && apu.ch2.enabled
+		apu.ch3.enabled = apu.ch3.length.clock() && apu.ch3.enabled
+		apu.ch4.enabled = apu.ch4.length.clock() && apu.ch4.enabled
+	}
+
+	if step == 2 || step == 6 {
+		apu.ch1.clockSweep()
+	}
+
+	if step == 7 {
+		apu.ch1.env.clock(apu.ch1.regs[2])
+		apu.ch2.env.clock(apu.ch2.regs[2])
+		apu.ch4.env.clock(apu.ch4.regs[2])
+	}
+}
+
+// Advance all the channels by the given number of cycles, and generate samples
+func (apu *APU) cycle(cycles int) {
+	if apu.power {
+		apu.ch1.step(cycles)
+		apu.ch2.step(cycles)
+		apu.ch3.step(cycles)
+		apu.ch4.step(cycles)
+	}
+
+	apu.sampleCounter += cycles * SAMPLE_RATE
+	for apu.sampleCounter >= CLOCK_SPEED {
+		apu.sampleCounter -= CLOCK_SPEED
+		apu.generateSample()
+	}
+}
+
+// Mix the output of the four channels into a single stereo sample
+func (apu *APU) generateSample() {
+	// Each DAC turns the 0-15 output of the channel into an analog level
+	outputs := [4]float64{}
+	dacs := [4]bool{apu.ch1.dacEnabled(), apu.ch2.dacEnabled(), apu.ch3.dacEnabled(), apu.ch4.dacEnabled()}
+	levels := [4]byte{apu.ch1.output(), apu.ch2.output(), apu.ch3.output(apu.waveRAM[:]), apu.ch4.output()}
+	for i := range outputs {
+		if dacs[i] && apu.power {
+			outputs[i] = float64(levels[i]) / 15
+		}
+
+		// Muted channels still show on the scope
+		apu.scopes[i][apu.scopePos] = float32(outputs[i])
+		if apu.muted[i] {
+			outputs[i] = 0
+		}
+	}
+	apu.scopePos = (apu.scopePos + 1) % SCOPE_SIZE
+
+	// NR51 pans each channel left and/or right, NR50 sets the volume of each side
+	nr51 := apu.regs[NR51-NR10]
+	nr50 := apu.regs[NR50-NR10]
+	left, right := 0.0, 0.0
+	for i, out := range outputs {
+		if checkBit(nr51, uint(i+4)) {
+			left += out
+		}
+		if checkBit(nr51, uint(i)) {
+			right += out
+		}
+	}
+
+	left = left / 4 * float64((nr50>>4)&0x07+1) / 8
+	right = right / 4 * float64(nr50&0x07+1) / 8
+
+	left = apu.highPass(left, &apu.capLeft)
+	right = apu.highPass(right, &apu.capRight)
+
+	if len(apu.samples) >= MAX_BUFFERED_SAMPLES {
+		// Nothing is reading the samples, so throw away the oldest half
+		apu.samples = append(apu.samples[:0], apu.samples[MAX_BUFFERED_SAMPLES/2:]...)
+	}
+	apu.samples = append(apu.samples, toSample(left), toSample(right))
+}
+
+// Charge factor of the high-pass capacitor, 0.999958 per cycle scaled to the sample rate
+var highPassCharge = math.Pow(0.999958, float64(CLOCK_SPEED)/SAMPLE_RATE)
+
+// Like the capacitor on the real hardware, this slowly pulls the output back to zero
+func (apu *APU) highPass(in float64, capacitor *float64) float64 {
+	out := in - *capacitor
+	*capacitor = in - out*highPassCharge
+
+	return out
+}
+
+func toSample(v float64) int16 {
+	v = min(1, max(-1, v))
+	return int16(v * 32767)
+}
+
+// Copy samples out of the buffer, as interleaved left & right values, removing them
+func (apu *APU) readSamples(p []int16) int {
+	n := copy(p, apu.samples)
+	// Keep a whole number of stereo pairs in the buffer
+	n -= n % 2
+	apu.samples = append(apu.samples[:0], apu.samples[n:]...)
+
+	return n
+}
+
+// Copy the recent output of a channel 0-3, oldest sample first
+func (apu *APU) scope(channel int) []float32 {
+	out := make([]float32, 0, SCOPE_SIZE)
+	out = append(out, apu.scopes[channel][apu.scopePos:]...)
+	out = append(out, apu.scopes[channel][:apu.scopePos]...)
+
+	return out
+}
+
+// Load the length counter from the register value, which counts up to the maximum
+func (l *lengthCounter) load(data byte) {
+	l.counter = l.max - int(data)
+}
+
+// Clock the length counter, returns false when the channel should be switched off
+func (l *lengthCounter) clock() bool {
+	if l.enabled && l.counter > 0 {
+		l.counter--
+		return l.counter > 0
+	}
+
+	return true
+}
+
+// Handle the length enable & trigger bits of NRx4, returns false if the channel
+// should be switched off, which can happen due to the extra clocking quirk
+func (l *lengthCounter) writeControl(data byte, extraClock bool) bool {
+	wasEnabled := l.enabled
+	l.enabled = checkBit(data, 6)
+	ok := true
+
+	if extraClock && !wasEnabled && l.enabled && l.counter > 0 {
+		l.counter--
+		if l.counter == 0 && !checkBit(data, 7) {
+			ok = false
+		}
+	}
+
+	// Triggering with the counter at zero reloads it
+	if checkBit(data, 7) && l.counter == 0 {
+		l.counter = l.max
+		if l.enabled && extraClock {
+			l.counter--
+		}
+	}
+
+	return ok
+}
+
+// Reset the envelope on trigger, nrx2 is the envelope register
+func (e *envelope) trigger(nrx2 byte) {
+	e.volume = nrx2 >> 4
+	e.timer = envelopePeriod(nrx2)
+}
+
+// Clock the envelope at 64Hz, moving the volume up or down once each period
+func (e *envelope) clock(nrx2 byte) {
+	if nrx2&0x07 == 0 {
+		return
+	}
+
+	e.timer--
+	if e.timer > 0 {
+		return
+	}
+
+	e.timer = envelopePeriod(nrx2)
+	if checkBit(nrx2, 3) && e.volume < 15 {
+		e.volume++
+	} else if !checkBit(nrx2, 3) && e.volume > 0 {
+		e.volume--
+	}
+}
+
+// A period of zero is treated as eight
+func envelopePeriod(nrx2 byte) int {
+	if nrx2&0x07 == 0 {
+		return 8
+	}
+
+	return int(nrx2 & 0x07)
+}
+
+// ==== Square channels 1 & 2 ===================================
+
+func (ch *squareChannel) freq() int {
+	return int(ch.regs[4]&0x07)<<8 | int(ch.regs[3])
+}
+
+func (ch *squareChannel) setFreq(freq int) {
+	ch.regs[3] = byte(freq)
+	ch.regs[4] = ch.regs[4]&0xF8 | byte(freq>>8)&0x07
+}
+
+// The DAC is on when any of the upper 5 bits of NRx2 are set
+func (ch *squareChannel) dacEnabled() bool {
+	return ch.regs[2]&0xF8 != 0
+}
+
+func (ch *squareChannel) writeControl(data byte, extraClock bool, hasSweep bool) {
+	if !ch.length.writeControl(data, extraClock) {
+		ch.enabled = false
+	}
+
+	if !checkBit(data, 7) {
+		return
+	}
+
+	// Trigger the channel
+	ch.enabled = ch.dacEnabled()
+	ch.timer = (2048 - ch.freq()) * 4
+	ch.env.trigger(ch.regs[2])
+
+	if hasSweep {
+		ch.sweepShadow = ch.freq()
+		ch.sweepTimer = ch.sweepPeriod()
+		ch.sweepEnabled = ch.regs[0]&0x70 != 0 || ch.regs[0]&0x07 != 0
+		ch.sweepNegUsed = false
+
+		// Overflow check is done straight away, if there's a shift
+		if ch.regs[0]&0x07 != 0 && ch.sweepCalc() > 2047 {
+			ch.enabled = false
+		}
+	}
+}
+
+func (ch *squareChannel) step(cycles int) {
+	ch.timer -= cycles
+	for ch.timer <= 0 {
+		ch.timer += (2048 - ch.freq()) * 4
+		ch.dutyStep = (ch.dutyStep + 1) & 7
+	}
+}
+
+func (ch *squareChannel) output() byte {
+	if !ch.enabled {
+		return 0
+	}
+
+	duty := dutyPatterns[ch.regs[1]>>6]
+	if duty>>(7-ch.dutyStep)&1 == 0 {
+		return 0
+	}
+
+	return ch.env.volume
+}
+
+// A sweep period of zero is treated as eight
+func (ch *squareChannel) sweepPeriod() int {
+	period := int(ch.regs[0]>>4) & 0x07
+	if period == 0 {
+		return 8
+	}
+
+	return period
+}
+
+// Calculate the next frequency of the sweep, from the shadow frequency
+func (ch *squareChannel) sweepCalc() int {
+	delta := ch.sweepShadow >> (ch.regs[0] & 0x07)
+	if checkBit(ch.regs[0], 3) {
+		ch.sweepNegUsed = true
+		return ch.sweepShadow - delta
+	}
+
+	return ch.sweepShadow + delta
+}
+
+// Clock the frequency sweep at 128Hz, going over 2047 switches the channel off
+func (ch *squareChannel) clockSweep() {
+	ch.sweepTimer--
+	if ch.sweepTimer > 0 {
+		return
+	}
+
+	ch.sweepTimer = ch.sweepPeriod()
+	if !ch.sweepEnabled || ch.regs[0]&0x70 == 0 {
+		return
+	}
+
+	freq := ch.sweepCalc()
+	if freq > 2047 {
+		ch.enabled = false
+		return
+	}
+
+	if ch.regs[0]&0x07 != 0 {
+		ch.sweepShadow = freq
+		ch.setFreq(freq)
+
+		// The new frequency is checked again for overflow, but not used
+		if ch.sweepCalc() > 2047 {
+			ch.enabled = false
+		}
+	}
+}
+
+// ==== Wave channel 3 ===================================
+
+func (ch *waveChannel) freq() int {
+	return int(ch.regs[4]&0x07)<<8 | int(ch.regs[3])
+}
+
+// The DAC is switched on by bit 7 of NR30
+func (ch *waveChannel) dacEnabled() bool {
+	return checkBit(ch.regs[0], 7)
+}
+
+func (ch *waveChannel) writeControl(data byte, extraClock bool, waveRAM []byte) {
+	if !ch.length.writeControl(data, extraClock) {
+		ch.enabled = false
+	}
+
+	if checkBit(data, 7) {
+		// On DMG retriggering on the tick before a sample is read corrupts wave RAM, the
+		// first byte or the first 4 bytes are overwritten by the ones about to be read
+		if ch.enabled && ch.dacEnabled() && ch.timer == 1 {
+			next := int((ch.position+1)/2) & 0x0F
+			if next < 4 {
+				waveRAM[0] = waveRAM[next]
+			} else {
+				copy(waveRAM[:4], waveRAM[next&^3:])
+			}
+		}
+
+		// There's a short delay before the first sample is read
+		ch.enabled = ch.dacEnabled()
+		ch.timer = 2048 - ch.freq() + 3
+		ch.position = 0
+	}
+}
+
+func (ch *waveChannel) step(cycles int) {
+	ch.justRead = false
+	for ticks := cycles / 2; ticks > 0; ticks-- {
+		ch.justRead = false
+
+		ch.timer--
+		if ch.timer <= 0 {
+			ch.timer = 2048 - ch.freq()
+			ch.position = (ch.position + 1) & 31
+			ch.justRead = true
+		}
+	}
+}
+
+func (ch *waveChannel) output(waveRAM []byte) byte {
+	if !ch.enabled {
+		return 0
+	}
+
+	// Two 4-bit samples per byte, the high nibble is played first
+	sample := waveRAM[ch.position/2]
+	if ch.position%2 == 0 {
+		sample >>= 4
+	}
+	sample &= 0x0F
+
+	// Volume code in bits 5-6 of NR32, 0 = mute, 1 = 100%, 2 = 50%, 3 = 25%
+	switch (ch.regs[2] >> 5) & 0x03 {
+	case 0:
+		return 0
+	case 2:
+		return sample >> 1
+	case 3:
+		return sample >> 2
+	}
+
+	return sample
+}
+
+// ==== Noise channel 4 ===================================
+
+// The DAC is on when any of the upper 5 bits of NR42 are set
+func (ch *noiseChannel) dacEnabled() bool {
+	return ch.regs[2]&0xF8 != 0
+}
+
+// Timer period from NR43, a divisor shifted left by the clock shift
+func (ch *noiseChannel) period() int {
+	divisor := int(ch.regs[3]&0x07) * 16
+	if divisor == 0 {
+		divisor = 8
+	}
+
+	return divisor << (ch.regs[3] >> 4)
+}
+
+func (ch *noiseChannel) writeControl(data byte, extraClock bool) {
+	if !ch.length.writeControl(data, extraClock) {
+		ch.enabled = false
+	}
+
+	if checkBit(data, 7) {
+		ch.enabled = ch.dacEnabled()
+		ch.timer = ch.period()
+		ch.lfsr = 0x7FFF
+		ch.env.trigger(ch.regs[2])
+	}
+}
+
+func (ch *noiseChannel) step(cycles int) {
+	ch.timer -= cycles
+	for ch.timer <= 0 {
+		ch.timer += ch.period()
+
+		// XOR the lowest 2 bits, shift right and put the result in bit 14,
+		// and also bit 6 when in 7-bit mode (NR43 bit 3)
+		bit := (ch.lfsr ^ ch.lfsr>>1) & 1
+		ch.lfsr = ch.lfsr>>1 | bit<<14
+		if checkBit(ch.regs[3], 3) {
+			ch.lfsr = ch.lfsr&^(1<<6) | bit<<6
+		}
+	}
+}
+
+func (ch *noiseChannel) output() byte {
+	if !ch.enabled || ch.lfsr&1 == 1 {
+		return 0
+	}
+
+	return ch.env.volume
+}
@@ -0,0 +1,196 @@
+package gameboy
+
+import "testing"
+
+// Registers of each channel, and values that switch its DAC on & off
+var testChannels = []struct {
+	name    string
+	length  uint16
+	dac     uint16
+	dacOn   byte
+	dacOff  byte
+	control uint16
+	max     int
+}{
+	{"square 1", NR11, NR12, 0xF0, 0x07, NR14, 64},
+	{"square 2", NR21, NR22, 0xF0, 0x07, NR24, 64},
+	{"wave", NR31, NR30, 0x80, 0x7F, NR34, 256},
+	{"noise", NR41, NR42, 0xF0, 0x07, NR44, 64},
+}
+
+func newTestAPU() *APU {
+	apu := NewAPU()
+	apu.write(NR52, 0x80)
+
+	return apu
+}
+
+// Whether a channel 0-3 is playing, from NR52
+func channelOn(apu *APU, channel int) bool {
+	return checkBit(apu.read(NR52), uint(channel))
+}
+
+func TestAPUReadMasks(t *testing.T) {
+	apu := newTestAPU()
+
+	for addr := uint16(NR10); addr < WAVE_RAM; addr++ {
+		if addr == NR52 {
+			continue
+		}
+
+		apu.write(addr, 0x00)
+		if got, want := apu.read(addr), soundReadMasks[addr-NR10]; got != want {
+			t.Errorf("0x%04X: read 0x%02X after writing 0, want 0x%02X", addr, got, want)
+		}
+		apu.write(addr, 0xFF)
+		if got := apu.read(addr); got != 0xFF {
+			t.Errorf("0x%04X: read 0x%02X after writing 0xFF, want 0xFF", addr, got)
+		}
+	}
+
+	apu = newTestAPU()
+	if got := apu.read(NR52); got != 0xF0 {
+		t.Errorf("NR52 0x%02X with no channels playing, want 0xF0", got)
+	}
+	apu.write(NR52, 0x00)
+	if got := apu.read(NR52); got != 0x70 {
+		t.Errorf("NR52 0x%02X with the power off, want 0x70", got)
+	}
+}
+
+func TestAPULengthCounter(t *testing.T) {
+	for i, ch := range testChannels {
+		t.Run(ch.name, func(t *testing.T) {
+			apu := newTestAPU()
+			apu.write(ch.dac, ch.dacOn)
+			apu.write(ch.length, byte(ch.max-2))
+			apu.write(ch.control, 0xC0)
+
+			// Only the even steps clock the length counters, so it runs out on step 2
+			for step, want := range []bool{true, true, false} {
+				apu.clockFrameSequencer()
+				if got := channelOn(apu, i); got != want {
+					t.Fatalf("channel on %v after step %d, want %v", got, step, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAPULengthDisabled(t *testing.T) {
+	apu := newTestAPU()
+	apu.write(NR12, 0xF0)
+	apu.write(NR11, 63)
+	apu.write(NR14, 0x80)
+
+	for i := 0; i < 16; i++ {
+		apu.clockFrameSequencer()
+	}
+	if !channelOn(apu, 0) {
+		t.Error("channel switched off with the length counter disabled")
+	}
+}
+
+func TestAPUEnvelope(t *testing.T) {
+	for _, tc := range []struct {
+		nrx2 byte
+		want byte
+	}{
+		{0xA1, 8},  // Down every step
+		{0xA2, 9},  // Down every other step
+		{0x09, 2},  // Up from zero
+		{0xF9, 15}, // Stops at the top
+		{0x11, 0},  // Stops at the bottom
+		{0xA0, 10}, // Period 0 doesn't change the volume
+	} {
+		apu := newTestAPU()
+		apu.write(NR22, tc.nrx2)
+		apu.write(NR24, 0x80)
+
+		// The envelopes are clocked on step 7, so twice in 16 steps
+		for i := 0; i < 16; i++ {
+			apu.clockFrameSequencer()
+		}
+		if got := apu.ch2.env.volume; got != tc.want {
+			t.Errorf("NR22 0x%02X: volume %d, want %d", tc.nrx2, got, tc.want)
+		}
+	}
+}
+
+func TestAPUSweepOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		nr10       byte
+		freq       int
+		onTrigger  bool
+		afterSweep bool
+	}{
+		{"on trigger", 0x11, 0x700, false, false},
+		{"after sweeping", 0x11, 0x500, true, false},
+		{"decreasing", 0x19, 0x500, true, true},
+		// With no shift the check on trigger is skipped, but the sweep still checks
+		{"no shift", 0x10, 0x700, true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			apu := newTestAPU()
+			apu.write(NR10, tc.nr10)
+			apu.write(NR12, 0xF0)
+			apu.write(NR13, byte(tc.freq))
+			apu.write(NR14, 0x80|byte(tc.freq>>8))
+
+			if got := channelOn(apu, 0); got != tc.onTrigger {
+				t.Fatalf("channel on %v after triggering, want %v", got, tc.onTrigger)
+			}
+
+			// The sweep is clocked on step 2
+			for i := 0; i < 3; i++ {
+				apu.clockFrameSequencer()
+			}
+			if got := channelOn(apu, 0); got != tc.afterSweep {
+				t.Errorf("channel on %v after sweeping, want %v", got, tc.afterSweep)
+			}
+		})
+	}
+}
+
+func TestAPUSweepFrequency(t *testing.T) {
+	apu := newTestAPU()
+	apu.write(NR10, 0x19)
+	apu.write(NR12, 0xF0)
+	apu.write(NR13, 0x00)
+	apu.write(NR14, 0x85)
+
+	for i := 0; i < 3; i++ {
+		apu.clockFrameSequencer()
+	}
+	if got := apu.ch1.freq(); got != 0x280 {
+		t.Errorf("frequency 0x%03X after sweeping down, want 0x280", got)
+	}
+}
+
+func TestAPUDACOff(t *testing.T) {
+	for i, ch := range testChannels {
+		t.Run(ch.name, func(t *testing.T) {
+			apu := newTestAPU()
+
+			apu.write(ch.dac, ch.dacOff)
+			apu.write(ch.control, 0x80)
+			if channelOn(apu, i) {
+				t.Fatal("channel triggered with the DAC off")
+			}
+
+			apu.write(ch.dac, ch.dacOn)
+			apu.write(ch.control, 0x80)
+			if !channelOn(apu, i) {
+				t.Fatal("channel not triggered with the DAC on")
+			}
+
+			// Switching the DAC off stops the channel, switching it back on doesn't restart it
+			apu.write(ch.dac, ch.dacOff)
+			apu.write(ch.dac, ch.dacOn)
+			if channelOn(apu, i) {
+				t.Error("channel still on after switching the DAC off")
+			}
+		})
+	}
+}
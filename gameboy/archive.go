@@ -0,0 +1,107 @@
+package gameboy
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Magic bytes at the start of the compressed containers we can unpack
+var zipMagic = []byte{'P', 'K', 0x03, 0x04}
+var gzipMagic = []byte{0x1F, 0x8B}
+
+// Split a ROM path into the file on disk and an optional entry inside an archive
+// using the "archive.zip#inner.gb" syntax. If the whole path exists it's used as is
+func splitROMPath(fileName string) (string, string) {
+	if _, err := os.Stat(fileName); err == nil {
+		return fileName, ""
+	}
+
+	if i := strings.LastIndex(fileName, "#"); i >= 0 {
+		return fileName[:i], fileName[i+1:]
+	}
+
+	return fileName, ""
+}
+
+// Extract the ROM from a zip or gzip container, plain ROM images are returned unchanged
+// For zip files the named entry is used, or the first .gb/.gbc file if the name is empty
+func unpackROM(data []byte, entry string) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, zipMagic):
+		return unzipROM(data, entry)
+
+	case bytes.HasPrefix(data, gzipMagic):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return readROM(r)
+	}
+
+	if entry != "" {
+		return nil, fmt.Errorf("can't open %s, ROM is not a zip archive", entry)
+	}
+
+	return data, nil
+}
+
+func unzipROM(data []byte, entry string) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		if entry != "" {
+			// Match either the full path in the archive or just the file name
+			if f.Name != entry && path.Base(f.Name) != entry {
+				continue
+			}
+		} else {
+			ext := strings.ToLower(path.Ext(f.Name))
+			if ext != ".gb" && ext != ".gbc" {
+				continue
+			}
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return readROM(r)
+	}
+
+	if entry != "" {
+		return nil, fmt.Errorf("%s not found in zip archive", entry)
+	}
+
+	return nil, fmt.Errorf("no .gb or .gbc file found in zip archive")
+}
+
+// Read a decompressed ROM, stopping early if it's too large so a bad archive can't eat all memory
+func readROM(r io.Reader) ([]byte, error) {
+	rom, err := io.ReadAll(io.LimitReader(r, MAX_ROM_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rom) > MAX_ROM_SIZE {
+		return nil, fmt.Errorf("%w: decompressed ROM is over %d bytes", ErrROMTooLarge, MAX_ROM_SIZE)
+	}
+
+	return rom, nil
+}
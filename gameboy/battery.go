@@ -0,0 +1,159 @@
+package gameboy
+
+import (
+	"encoding/binary"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How long to wait after the last write to cartridge RAM before saving it, in cycles
+const SAVE_DELAY = CLOCK_SPEED * 3
+
+// Size of the RTC block appended to MBC3 saves, in the format used by VBA-M, BGB & others
+const RTC_SAVE_SIZE = 48
+
+// Cartridges with a battery keep their RAM when powered off, this is used to
+// get the contents of the RAM for writing to a .sav file, and to restore it
+type battery interface {
+	saveRAM() []byte
+	loadRAM(data []byte)
+}
+
+// Check the cartridge type in the header for a battery
+func hasBattery(cartType byte) bool {
+	switch cartType {
+	case CART_MBC1_RAM_BATT, CART_MBC2_BATT, CART_ROM_RAM_BATT, CART_MBC3_TIMER_BATT,
+		CART_MBC3_TIMER_RAM_BATT, CART_MBC3_RAM_BATT, CART_MBC5_RAM_BATT, CART_MBC5_RUMBLE_RAM_BATT:
+		return true
+	}
+
+	return false
+}
+
+// SaveBaseName is the path of a ROM, or the archive it's in, without the extension.
+// Saves are named after this, e.g. game.gb and game.zip#game.gb both save to game.sav
+func SaveBaseName(romPath string) string {
+	fileName, _ := splitROMPath(romPath)
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
+}
+
+// SaveRAM writes the battery backed cartridge RAM to the .sav file next to the ROM
+// It's safe to call this for carts without a battery, it does nothing
+func (gb *Gameboy) SaveRAM() error {
+	cart, ok := gb.mapper.cart.(battery)
+	if !ok || gb.savePath == "" {
+		return nil
+	}
+
+	gb.savePending = false
+	log.Printf("Saving cartridge RAM to: %s\n", gb.savePath)
+
+	return os.WriteFile(gb.savePath, cart.saveRAM(), 0644)
+}
+
+// Load the .sav file next to the ROM into the cartridge RAM, if it exists
+func (gb *Gameboy) loadRAM() {
+	cart, ok := gb.mapper.cart.(battery)
+	if !ok || gb.savePath == "" {
+		return
+	}
+
+	data, err := os.ReadFile(gb.savePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to read save file: %s", err)
+		}
+		return
+	}
+
+	log.Printf("Loaded cartridge RAM from: %s\n", gb.savePath)
+	cart.loadRAM(data)
+}
+
+// Called after every instruction, saves the RAM once writes to it have settled down
+func (gb *Gameboy) updateSave(cycles int) {
+	if gb.mapper.ramWritten {
+		gb.mapper.ramWritten = false
+		gb.savePending = true
+		gb.saveCountdown = SAVE_DELAY
+		return
+	}
+
+	if !gb.savePending {
+		return
+	}
+
+	gb.saveCountdown -= cycles
+	if gb.saveCountdown <= 0 {
+		if err := gb.SaveRAM(); err != nil {
+			log.Printf("Unable to write save file: %s", err)
+		}
+	}
+}
+
+// Saves for every cart but MBC3 are the raw RAM contents and nothing else
+func (c *romOnly) saveRAM() []byte { return c.ram }
+
+func (c *romOnly) loadRAM(data []byte) { copy(c.ram, data) }
+
+func (c *mbc1) saveRAM() []byte { return c.ram }
+
+func (c *mbc1) loadRAM(data []byte) { copy(c.ram, data) }
+
+func (c *mbc2) saveRAM() []byte { return c.ram }
+
+func (c *mbc2) loadRAM(data []byte) { copy(c.ram, data) }
+
+func (c *mbc5) saveRAM() []byte { return c.ram }
+
+func (c *mbc5) loadRAM(data []byte) { copy(c.ram, data) }
+
+// MBC3 saves have the RTC registers appended after the RAM, as 5 current & 5 latched
+// registers each stored in a 32-bit little endian word, followed by a 64-bit timestamp
+func (c *mbc3) saveRAM() []byte {
+	data := make([]byte, len(c.ram))
+	copy(data, c.ram)
+
+	if !c.hasRTC {
+		return data
+	}
+
+	c.tick()
+
+	trailer := make([]byte, RTC_SAVE_SIZE)
+	for i := byte(0); i < 5; i++ {
+		binary.LittleEndian.PutUint32(trailer[i*4:], uint32(c.rtc.readReg(RTC_S+i)))
+		binary.LittleEndian.PutUint32(trailer[20+i*4:], uint32(c.latched.readReg(RTC_S+i)))
+	}
+	binary.LittleEndian.PutUint64(trailer[40:], uint64(c.lastTick.Unix()))
+
+	return append(data, trailer...)
+}
+
+func (c *mbc3) loadRAM(data []byte) {
+	copy(c.ram, data)
+
+	// Some emulators write a 44 byte trailer with a 32-bit timestamp, so accept both
+	trailer := data[min(len(c.ram), len(data)):]
+	if !c.hasRTC || len(trailer) < 44 {
+		return
+	}
+
+	for i := byte(0); i < 5; i++ {
+		c.rtc.writeReg(RTC_S+i, byte(binary.LittleEndian.Uint32(trailer[i*4:])))
+		c.latched.writeReg(RTC_S+i, byte(binary.LittleEndian.Uint32(trailer[20+i*4:])))
+	}
+
+	var timestamp int64
+	if len(trailer) >= RTC_SAVE_SIZE {
+		timestamp = int64(binary.LittleEndian.Uint64(trailer[40:]))
+	} else {
+		timestamp = int64(binary.LittleEndian.Uint32(trailer[40:]))
+	}
+
+	// The clock kept running while the emulator was off, it catches up on the next tick
+	c.lastTick = time.Unix(timestamp, 0)
+}
@@ -0,0 +1,71 @@
+package gameboy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveBaseName(t *testing.T) {
+	dir := t.TempDir()
+
+	// A ROM with a # in its name is used as is when the file exists
+	hashed := filepath.Join(dir, "a#b.gb")
+	if err := os.WriteFile(hashed, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{filepath.Join(dir, "game.gb"), filepath.Join(dir, "game")},
+		{filepath.Join(dir, "games.zip"), filepath.Join(dir, "games")},
+		{filepath.Join(dir, "games.zip#tetris.gb"), filepath.Join(dir, "games")},
+		{filepath.Join(dir, "games.zip#dir/tetris.gb"), filepath.Join(dir, "games")},
+		{hashed, filepath.Join(dir, "a#b")},
+		{"", ""},
+	} {
+		if got := SaveBaseName(tc.path); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestSteppingSavesRAM(t *testing.T) {
+	gb, err := NewGameboy(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rom := testROM(2)
+	rom[HEADER_CART_TYPE] = CART_MBC1_RAM_BATT
+	rom[HEADER_RAM_SIZE] = 0x02
+	if err := gb.LoadROMBytes(rom); err != nil {
+		t.Fatal(err)
+	}
+	gb.savePath = filepath.Join(t.TempDir(), "game.sav")
+
+	// LD (HL), A with cartridge RAM enabled, then HALT
+	loadProgram(gb, 0x77, 0x76)
+	gb.mapper.write(0x0000, 0x0A)
+	gb.cpu.hl = EXT_RAM
+	gb.cpu.setA(0x42)
+
+	gb.Update(0)
+	if !gb.savePending {
+		t.Fatal("no save pending after stepping over a RAM write")
+	}
+
+	// Skip most of the wait before the save is written
+	gb.saveCountdown = 8
+	gb.Update(0)
+	gb.Update(0)
+
+	data, err := os.ReadFile(gb.savePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 0x42 {
+		t.Errorf("saved 0x%02X, want 0x42", data[0])
+	}
+}
@@ -17,9 +17,20 @@ type CPU struct {
 	// Memory
 	mapper *Mapper
 
+	// Runs the rest of the machine, called as each memory access or internal delay happens
+	clock func(cycles int)
+
+	// Cycles the machine has been run for so far in the current instruction
+	cycles int
+
 	// Special internal flags
-	ime    bool // Interrupt Master Enable
-	halted bool // Halt state
+	ime       bool // Interrupt Master Enable
+	enableIME bool // Set by EI, IME is enabled once the next instruction starts
+	eiDelayed bool // IME was enabled by EI as the current instruction started, see halt
+	halted    bool // Halt state
+	haltBug   bool // PC fails to increment on the next fetch, see halt
+	stopped   bool // Stop state, only left when a button is pressed
+	branched  bool // Set when a conditional jump, call or return was taken
 
 	// Debugging
 	opDebug     []byte
@@ -48,21 +59,35 @@ func NewCPU(mapper *Mapper) *CPU {
 }
 
 func (cpu *CPU) ExecuteNext(skipBreak bool) (cyclesSpent int) {
+	cpu.cycles = 0
+
+	// EI takes effect after the instruction following it, so EI & RET can't be interrupted
+	cpu.eiDelayed = cpu.enableIME
+	if cpu.enableIME {
+		cpu.enableIME = false
+		cpu.ime = true
+	}
+
+	if cpu.stopped {
+		// Everything is stopped, the clock included, but time still passes for the frontend
+		return 4
+	}
+
 	if cpu.halted {
 		// Even if halted, we still spend some cycles
+		cpu.tick()
 		return 4
 	}
 
 	currentPC := cpu.pc
 
-	// Fetch the next instruction, this will also increment the PC
-	opcode := cpu.fetchPC()
+	// Peek at the next instruction, it's only fetched once we know we can run it
+	opcode := cpu.mapper.read(currentPC)
 
 	// Check if we have hit a breakpoint
 	for _, bp := range cpu.breakpoints {
 		if bp == currentPC && !skipBreak {
 			log.Printf("!!! Breakpoint hit at 0x%04X\n", currentPC)
-			cpu.pc = currentPC
 			return -1
 		}
 	}
@@ -70,7 +95,6 @@ func (cpu *CPU) ExecuteNext(skipBreak bool) (cyclesSpent int) {
 	// Check if the opcode is valid
 	if opcodes[opcode] == nil {
 		log.Printf("!!! Unknown opcode: 0x%02X at 0x%04X\n", opcode, currentPC)
-		cpu.pc = currentPC
 		return -1
 	}
 
@@ -81,36 +105,99 @@ func (cpu *CPU) ExecuteNext(skipBreak bool) (cyclesSpent int) {
 		}
 	}
 
+	// Fetch the instruction, this will also increment the PC
+	// It's normally what we peeked, but during DMA it can be 0xFF
+	opcode = cpu.fetchPC()
+	if cpu.haltBug {
+		cpu.haltBug = false
+		cpu.pc--
+	}
+
+	// CB prefixed opcodes have their own costs, peek at the next byte before it's fetched
+	cycles := opcodeCycles[opcode]
+	if opcode == 0xCB {
+		cycles = cbOpcodeCycles[cpu.mapper.read(cpu.pc)]
+	}
+
 	// Decode & execute the opcode
+	cpu.branched = false
 	opcodes[opcode](cpu)
 
-	cycles := opcodeLengths[opcode]
+	if cpu.branched {
+		cycles = opcodeCyclesBranched[opcode]
+	}
+
+	// Whatever isn't spent on memory accesses is internal to the CPU
+	cpu.idle(cycles)
+
 	return cycles
 }
 
-func (cpu *CPU) handleInterrupt(interrupt byte) {
-	// Disable interrupts
-	cpu.ime = false
+// Advance the rest of the machine by one machine cycle, 4 clock cycles
+func (cpu *CPU) tick() {
+	cpu.cycles += 4
+	if cpu.clock != nil {
+		cpu.clock(4)
+	}
+}
+
+// Run out the rest of an instruction taking the given number of cycles
+func (cpu *CPU) idle(total int) {
+	for cpu.cycles < total {
+		cpu.tick()
+	}
+}
+
+// Every memory access by the CPU takes a machine cycle, the rest of the machine is run
+// first so the access happens at the end of that cycle, as seen by the PPU & timer
+func (cpu *CPU) read(addr uint16) byte {
+	cpu.tick()
+	if cpu.mapper.dmaBlocked(addr) {
+		return 0xFF
+	}
+
+	return cpu.mapper.read(addr)
+}
+
+func (cpu *CPU) write(addr uint16, data byte) {
+	cpu.tick()
+	if cpu.mapper.dmaBlocked(addr) {
+		return
+	}
+
+	cpu.mapper.write(addr, data)
+}
 
-	// Clear the interrupt flag at the interrupt bit
-	cpu.mapper.write(IF, cpu.mapper.read(IF)&^interrupt)
-
-	// Push the current PC onto the stack
-	cpu.pushStack(cpu.pc)
-
-	// Jump to the interrupt handler
-	switch interrupt {
-	case 0x01:
-		cpu.pc = 0x0040 // VBLANK interrupt handler address
-	case 0x02:
-		cpu.pc = 0x0048 // LCD interrupt handler address
-	case 0x04:
-		cpu.pc = 0x0050 // Timer interrupt handler address
-	case 0x08:
-		cpu.pc = 0x0058 // Serial interrupt handler address
-	case 0x10:
-		cpu.pc = 0x0060 // Joypad interrupt handler address
+// Dispatching an interrupt takes 5 machine cycles, two internal, two pushing the PC and one
+// jumping to the handler. Which interrupt is only decided after the upper byte of the PC is
+// pushed, if that push overwrote IE the dispatch can change, or be cancelled with PC set to 0
+func (cpu *CPU) handleInterrupt() {
+	cpu.cycles = 0
+	cpu.ime = false
+	cpu.tick()
+	cpu.tick()
+
+	cpu.sp--
+	cpu.write(cpu.sp, byte(cpu.pc>>8))
+	pending := cpu.mapper.read(IF) & cpu.mapper.read(IE) & INT_MASK
+
+	cpu.sp--
+	cpu.write(cpu.sp, byte(cpu.pc))
+
+	// Jump to the handler of the highest priority interrupt and clear its flag
+	// Handlers are 8 bytes apart; VBLANK 0x40, LCD 0x48, Timer 0x50, Serial 0x58, Joypad 0x60
+	cpu.pc = 0x0000
+	for i := 0; i < 5; i++ {
+		interrupt := byte(1) << i
+		if pending&interrupt != 0 {
+			cpu.mapper.write(IF, cpu.mapper.read(IF)&^interrupt)
+			cpu.pc = 0x0040 + uint16(i)*8
+			break
+		}
 	}
+
+	// Final cycle is spent loading the handler address into the PC
+	cpu.idle(INTERRUPT_CYCLES)
 }
 
 // Flag getters and setters
@@ -2,15 +2,15 @@ package gameboy
 
 // Fetches the next byte from memory and increments the program counter
 func (cpu *CPU) fetchPC() byte {
-	v := cpu.mapper.read(cpu.pc)
+	v := cpu.read(cpu.pc)
 	cpu.pc += 1
 	return v
 }
 
 // Fetches the next 16-bit word from memory and increments the program counter
 func (cpu *CPU) fetchPC16() uint16 {
-	lo := cpu.mapper.read(cpu.pc)
-	hi := cpu.mapper.read(cpu.pc + 1)
+	lo := cpu.read(cpu.pc)
+	hi := cpu.read(cpu.pc + 1)
 	cpu.pc += 2
 	return uint16(hi)<<8 | uint16(lo)
 }
@@ -21,11 +21,40 @@ func (cpu *CPU) callSub(addr uint16) {
 	cpu.pc = addr
 }
 
+// Halts the CPU until an interrupt is pending. If one is already pending the CPU doesn't
+// halt, and with IME off the PC then fails to increment after the next fetch (the HALT bug)
+// https://gbdev.io/pandocs/halt.html
+func (cpu *CPU) halt() {
+	if cpu.mapper.read(IF)&cpu.mapper.read(IE)&INT_MASK == 0 {
+		cpu.halted = true
+		return
+	}
+
+	switch {
+	case cpu.eiDelayed:
+		// EI just before HALT, the interrupt is serviced and returns to the HALT
+		cpu.pc--
+	case !cpu.ime:
+		cpu.haltBug = true
+	}
+}
+
+// Stops the CPU & the rest of the machine until a button is pressed, DIV is also reset
+// STOP is two bytes long, the second is skipped
+func (cpu *CPU) stop() {
+	cpu.pc++
+	cpu.mapper.write(DIV, 0)
+	cpu.stopped = true
+}
+
 // Pushes a 16-bit value onto the stack, often the PC but can be any value
 func (cpu *CPU) pushStack(addr uint16) {
+	// The SP is decremented in an internal cycle before anything is written
+	cpu.tick()
+
 	sp := cpu.sp
-	cpu.mapper.write(sp-1, byte(uint16(addr&0xFF00)>>8))
-	cpu.mapper.write(sp-2, byte(addr&0xFF))
+	cpu.write(sp-1, byte(uint16(addr&0xFF00)>>8))
+	cpu.write(sp-2, byte(addr&0xFF))
 	cpu.sp -= 2
 }
 
@@ -38,8 +67,8 @@ func (cpu *CPU) returnSub() {
 // Pops a 16-bit value from the stack
 func (cpu *CPU) popStack() uint16 {
 	sp := cpu.sp
-	lo := cpu.mapper.read(sp)
-	hi := cpu.mapper.read(sp + 1)
+	lo := cpu.read(sp)
+	hi := cpu.read(sp + 1)
 	cpu.sp += 2
 	return uint16(hi)<<8 | uint16(lo)
 }
@@ -0,0 +1,158 @@
+package gameboy
+
+import "testing"
+
+// Copy a program into WRAM and point the PC at it
+func loadProgram(gb *Gameboy, program ...byte) {
+	for i, b := range program {
+		gb.mapper.write(WRAM+uint16(i), b)
+	}
+	gb.cpu.pc = WRAM
+	gb.cpu.sp = 0xDFF0
+}
+
+func TestEIHaltReturnsToHalt(t *testing.T) {
+	gb := newTestGameboy(t)
+	loadProgram(gb, 0xFB, 0x76, 0x00) // EI, HALT, NOP
+	gb.mapper.write(IE, INT_TIMER)
+	gb.mapper.write(IF, INT_TIMER)
+
+	// Stepping runs one instruction, then checks for interrupts
+	gb.Update(0)
+	if gb.cpu.pc != WRAM+1 {
+		t.Fatalf("interrupt taken straight after EI, PC 0x%04X", gb.cpu.pc)
+	}
+
+	gb.Update(0)
+	if gb.cpu.pc != 0x50 {
+		t.Fatalf("PC 0x%04X after HALT, want the timer handler at 0x0050", gb.cpu.pc)
+	}
+	if gb.cpu.halted {
+		t.Error("halted with an interrupt pending")
+	}
+
+	ret := uint16(gb.mapper.read(gb.cpu.sp+1))<<8 | uint16(gb.mapper.read(gb.cpu.sp))
+	if ret != WRAM+1 {
+		t.Errorf("handler returns to 0x%04X, want the HALT at 0x%04X", ret, WRAM+1)
+	}
+}
+
+func TestStopWaitsForButton(t *testing.T) {
+	gb := newTestGameboy(t)
+	loadProgram(gb, 0x10, 0x00, 0x00) // STOP, NOP
+	gb.cpu.ime = true
+	gb.mapper.write(IE, INT_TIMER|INT_JOYPAD)
+	gb.mapper.write(IF, INT_TIMER)
+
+	// The pending timer interrupt isn't serviced in STOP
+	gb.Update(0)
+	if !gb.cpu.stopped || gb.cpu.pc != WRAM+2 {
+		t.Fatalf("stopped %v, PC 0x%04X after STOP, want stopped at 0x%04X", gb.cpu.stopped, gb.cpu.pc, WRAM+2)
+	}
+
+	gb.RunFrame()
+	if !gb.cpu.stopped || gb.cpu.pc != WRAM+2 {
+		t.Fatalf("stopped %v, PC 0x%04X a frame later, want still stopped", gb.cpu.stopped, gb.cpu.pc)
+	}
+
+	// A press wakes the CPU at the start of the next frame, and the timer handler runs
+	gb.Buttons.Set("A", true)
+	gb.RunFrame()
+	if gb.cpu.stopped {
+		t.Fatal("still stopped after pressing a button")
+	}
+	if gb.cpu.sp != 0xDFEE || gb.mapper.read(IF)&INT_TIMER != 0 {
+		t.Errorf("SP 0x%04X, IF 0x%02X, want the timer interrupt taken", gb.cpu.sp, gb.mapper.read(IF))
+	}
+}
+
+// Runs one instruction with the given flags, returns the cycles it took & whether it jumped
+func runBranch(t *testing.T, flags byte, program ...byte) (int, bool) {
+	t.Helper()
+
+	gb := newTestGameboy(t)
+	loadProgram(gb, program...)
+	gb.cpu.af = 0x0100 | uint16(flags)
+	// Return address for RET
+	gb.mapper.write(0xDFF0, 0x00)
+	gb.mapper.write(0xDFF1, 0xC1)
+
+	cycles := gb.cpu.ExecuteNext(true)
+	if cycles != gb.cpu.cycles {
+		t.Errorf("0x%02X: returned %d cycles, but ran for %d", program[0], cycles, gb.cpu.cycles)
+	}
+
+	return cycles, gb.cpu.pc != WRAM+uint16(len(program))
+}
+
+func TestBranchCycles(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		program  []byte
+		notTaken int
+		taken    int
+	}{
+		{"JR NZ", []byte{0x20, 0x10}, 8, 12},
+		{"JR Z", []byte{0x28, 0x10}, 8, 12},
+		{"JR NC", []byte{0x30, 0x10}, 8, 12},
+		{"JR C", []byte{0x38, 0x10}, 8, 12},
+		{"JP NZ", []byte{0xC2, 0x00, 0xC1}, 12, 16},
+		{"JP Z", []byte{0xCA, 0x00, 0xC1}, 12, 16},
+		{"JP NC", []byte{0xD2, 0x00, 0xC1}, 12, 16},
+		{"JP C", []byte{0xDA, 0x00, 0xC1}, 12, 16},
+		{"CALL NZ", []byte{0xC4, 0x00, 0xC1}, 12, 24},
+		{"CALL Z", []byte{0xCC, 0x00, 0xC1}, 12, 24},
+		{"CALL NC", []byte{0xD4, 0x00, 0xC1}, 12, 24},
+		{"CALL C", []byte{0xDC, 0x00, 0xC1}, 12, 24},
+		{"RET NZ", []byte{0xC0}, 8, 20},
+		{"RET Z", []byte{0xC8}, 8, 20},
+		{"RET NC", []byte{0xD0}, 8, 20},
+		{"RET C", []byte{0xD8}, 8, 20},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			op := tc.program[0]
+			if opcodeCycles[op] != tc.notTaken || opcodeCyclesBranched[op] != tc.taken {
+				t.Errorf("tables have %d/%d cycles, want %d/%d",
+					opcodeCycles[op], opcodeCyclesBranched[op], tc.notTaken, tc.taken)
+			}
+
+			// Bits 3-4 of the opcode pick the condition: NZ, Z, NC or C
+			cond := op >> 3 & 3
+			flag := byte(0x80)
+			if cond >= 2 {
+				flag = 0x10
+			}
+			takenFlags, notTakenFlags := flag, byte(0)
+			if cond&1 == 0 {
+				takenFlags, notTakenFlags = 0, flag
+			}
+
+			if cycles, jumped := runBranch(t, notTakenFlags, tc.program...); jumped || cycles != tc.notTaken {
+				t.Errorf("not taken: jumped %v in %d cycles, want %d", jumped, cycles, tc.notTaken)
+			}
+			if cycles, jumped := runBranch(t, takenFlags, tc.program...); !jumped || cycles != tc.taken {
+				t.Errorf("taken: jumped %v in %d cycles, want %d", jumped, cycles, tc.taken)
+			}
+		})
+	}
+}
+
+func TestJumpCycles(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		program []byte
+		want    int
+	}{
+		{"JR", []byte{0x18, 0x10}, 12},
+		{"JP", []byte{0xC3, 0x00, 0xC1}, 16},
+		{"JP HL", []byte{0xE9}, 4},
+		{"CALL", []byte{0xCD, 0x00, 0xC1}, 24},
+		{"RET", []byte{0xC9}, 16},
+		{"RETI", []byte{0xD9}, 16},
+		{"RST", []byte{0xFF}, 16},
+	} {
+		if cycles, jumped := runBranch(t, 0, tc.program...); !jumped || cycles != tc.want {
+			t.Errorf("%s: jumped %v in %d cycles, want %d", tc.name, jumped, cycles, tc.want)
+		}
+	}
+}
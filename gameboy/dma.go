@@ -0,0 +1,67 @@
+package gameboy
+
+// Bytes copied to OAM by a DMA transfer, one each machine cycle
+const DMA_LENGTH = 0xA0
+
+// Cycles from writing DMA to the first byte being copied
+const DMA_START_DELAY = 8
+
+// OAM DMA copies 160 bytes from any page of memory into OAM, taking 160 machine cycles
+// https://gbdev.io/pandocs/OAM_DMA_Transfer.html
+type dmaTransfer struct {
+	source uint16 // Start of the page being copied
+	copied int    // Bytes copied so far
+	active bool   // While set the CPU is locked out of most of memory
+
+	// A new transfer starts after a delay, one already running carries on until then
+	next  uint16
+	delay int
+}
+
+func (m *Mapper) startDMA(page byte) {
+	m.dma.next = uint16(page) << 8
+	m.dma.delay = DMA_START_DELAY
+}
+
+// Advance any DMA transfer by the given number of cycles
+func (m *Mapper) cycleDMA(cycles int) {
+	for ; cycles > 0; cycles -= 4 {
+		if m.dma.delay > 0 {
+			m.dma.delay -= 4
+			if m.dma.delay <= 0 {
+				m.dma.delay = 0
+				m.dma.source = m.dma.next
+				m.dma.copied = 0
+				m.dma.active = true
+			}
+		}
+
+		if !m.dma.active {
+			continue
+		}
+
+		// The bus is held for the cycle after the last byte too
+		if m.dma.copied == DMA_LENGTH {
+			m.dma.active = false
+			continue
+		}
+
+		m.oam[m.dma.copied] = m.dmaRead(m.dma.source + uint16(m.dma.copied))
+		m.dma.copied++
+	}
+}
+
+// DMA from 0xE000 and up reads the work RAM, like the echo RAM, even past 0xFE00
+func (m *Mapper) dmaRead(addr uint16) byte {
+	if addr >= ECHO_RAM {
+		addr -= ECHO_RAM - WRAM
+	}
+
+	return m.read(addr)
+}
+
+// During DMA the CPU can only reach HRAM and the IO registers, which are on their own bus,
+// reads from anywhere else return 0xFF and writes are lost
+func (m *Mapper) dmaBlocked(addr uint16) bool {
+	return m.dma.active && addr < IO
+}
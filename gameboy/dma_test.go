@@ -0,0 +1,130 @@
+package gameboy
+
+import "testing"
+
+// Fill a page of WRAM with a pattern that's different for each page
+func fillPage(gb *Gameboy, page byte) {
+	for i := uint16(0); i < 0x100; i++ {
+		gb.mapper.write(uint16(page)<<8+i, page^byte(i))
+	}
+}
+
+// Start a DMA transfer as if the CPU had written DMA, at the end of a machine cycle
+func startTestDMA(t *testing.T, page byte) *Gameboy {
+	t.Helper()
+
+	gb := newTestGameboy(t)
+	fillPage(gb, 0xC0)
+	fillPage(gb, 0xC1)
+	fillPage(gb, 0xDE)
+	gb.mapper.write(HRAM, 0x5A)
+	gb.mapper.write(DMA, page)
+
+	return gb
+}
+
+func checkOAM(t *testing.T, gb *Gameboy, source uint16) {
+	t.Helper()
+
+	for i := uint16(0); i < DMA_LENGTH; i++ {
+		if got, want := gb.mapper.oam[i], gb.mapper.read(source+i); got != want {
+			t.Fatalf("OAM byte %d: 0x%02X, want 0x%02X from 0x%04X", i, got, want, source+i)
+		}
+	}
+}
+
+// Every CPU access takes a cycle, so each read here is the next cycle after writing DMA
+func TestDMABlocksTheBus(t *testing.T) {
+	gb := startTestDMA(t, 0xC0)
+	addrs := []uint16{0x0150, VRAM, WRAM + 1, OAM}
+
+	// The first cycle after the write is the start delay, then the bus is held for one
+	// cycle per byte
+	for cycle := 1; cycle <= DMA_LENGTH; cycle++ {
+		addr := addrs[cycle%len(addrs)]
+		blocked := cycle >= 2
+
+		if got := gb.cpu.read(addr); blocked && got != 0xFF {
+			t.Fatalf("cycle %d: read 0x%02X from 0x%04X, want 0xFF", cycle, got, addr)
+		} else if !blocked && got == 0xFF {
+			t.Fatalf("cycle %d: read 0xFF from 0x%04X, want the bus free", cycle, addr)
+		}
+	}
+
+	// The last byte is copied in the next cycle, OAM can be read in the one after
+	if got := gb.cpu.read(OAM); got != 0xFF {
+		t.Errorf("cycle %d: read 0x%02X from OAM, want 0xFF", DMA_LENGTH+1, got)
+	}
+	checkOAM(t, gb, 0xC000)
+	if got := gb.cpu.read(OAM); got != gb.mapper.oam[0] {
+		t.Errorf("cycle %d: read 0x%02X from OAM, want 0x%02X", DMA_LENGTH+2, got, gb.mapper.oam[0])
+	}
+}
+
+func TestDMAHRAMAndIOAccessible(t *testing.T) {
+	gb := startTestDMA(t, 0xC0)
+	cpu := gb.cpu
+
+	cpu.tick()
+	cpu.tick()
+	if !gb.mapper.dma.active {
+		t.Fatal("DMA not running 2 cycles after the write")
+	}
+
+	if got := cpu.read(HRAM); got != 0x5A {
+		t.Errorf("read 0x%02X from HRAM during DMA, want 0x5A", got)
+	}
+	if got := cpu.read(IF); got&0xE0 != 0xE0 {
+		t.Errorf("read 0x%02X from IF during DMA, want the IO register", got)
+	}
+
+	cpu.write(HRAM+1, 0x33)
+	cpu.write(WRAM, 0x33)
+	if gb.mapper.read(HRAM+1) != 0x33 {
+		t.Error("write to HRAM during DMA was lost")
+	}
+	if gb.mapper.read(WRAM) == 0x33 {
+		t.Error("write to WRAM during DMA wasn't blocked")
+	}
+}
+
+func TestDMARestart(t *testing.T) {
+	gb := startTestDMA(t, 0xC0)
+	cpu := gb.cpu
+
+	for i := 0; i < 50; i++ {
+		cpu.tick()
+	}
+	gb.mapper.write(DMA, 0xC1)
+
+	// The first transfer carries on through the start delay, so the bus is never freed
+	for cycle := 1; cycle <= DMA_LENGTH+1; cycle++ {
+		if got := cpu.read(WRAM); got != 0xFF {
+			t.Fatalf("cycle %d after restarting: read 0x%02X, want 0xFF", cycle, got)
+		}
+	}
+	if got := cpu.read(WRAM); got != 0xC0 {
+		t.Errorf("read 0x%02X after the restarted DMA, want 0xC0", got)
+	}
+
+	checkOAM(t, gb, 0xC100)
+}
+
+func TestDMAFromEchoPages(t *testing.T) {
+	for _, tc := range []struct {
+		page   byte
+		source uint16
+	}{
+		{0xE0, 0xC000},
+		{0xE1, 0xC100},
+		// Past the echo RAM, this still reads WRAM instead of OAM & IO
+		{0xFE, 0xDE00},
+	} {
+		gb := startTestDMA(t, tc.page)
+		for i := 0; i < DMA_LENGTH+2; i++ {
+			gb.cpu.tick()
+		}
+
+		checkOAM(t, gb, tc.source)
+	}
+}
@@ -2,11 +2,12 @@ package gameboy
 
 import (
 	"fmt"
+	"image"
 	"io"
 	"log"
 	"os"
-
-	"github.com/hajimehoshi/ebiten/v2"
+	"strings"
+	"time"
 )
 
 const (
@@ -17,6 +18,18 @@ const (
 	INT_JOYPAD = 0x10
 )
 
+// Only the lower 5 bits of IF & IE are interrupts, lowest bit is the highest priority
+const INT_MASK = 0x1F
+
+// Clock speed of the DMG in cycles per second
+const CLOCK_SPEED = 4194304
+
+// Cycles in one video frame, 154 scanlines of 456 cycles
+const CYCLES_PER_FRAME = 70224
+
+// Cycles taken to push PC and jump to an interrupt handler
+const INTERRUPT_CYCLES = 20
+
 type Config struct {
 	BootROM     string   `yaml:"bootROM"`
 	Breakpoints []uint16 `yaml:"breakpoints"`
@@ -28,57 +41,80 @@ type Gameboy struct {
 	mapper  *Mapper
 	ppu     *PPU
 	cpu     *CPU
+	apu     *APU
+	timer   *Timer
 	Buttons *Buttons
 
-	divider int
+	Running bool
+	config  Config
+
+	// Source of wall time for cartridges with a real time clock, it can be
+	// replaced with a fake clock before calling LoadROM
+	Clock func() time.Time
 
-	Running      bool
-	config       Config
-	timerCounter int
+	// Called when the rumble motor in a rumble cartridge is switched on or off
+	OnRumble func(on bool)
+
+	// Cycles run past the end of the last frame by RunFrame
+	frameOvershoot int
+
+	// Parsed header of the loaded cartridge
+	header *Header
+
+	// Battery backed cartridge RAM is saved here, shortly after it was last written
+	savePath      string
+	savePending   bool
+	saveCountdown int
 }
 
-func NewGameboy(config Config) *Gameboy {
+func NewGameboy(config Config) (*Gameboy, error) {
 	log.Println("Initializing Gameboy")
 
 	buttons := &Buttons{}
-	mapper := NewMapper(buttons)
+	apu := NewAPU()
+	timer := NewTimer(apu)
+	mapper := NewMapper(buttons, apu, timer)
 	cpu := NewCPU(mapper)
 	ppu := NewPPU(mapper)
 	gb := Gameboy{
 		mapper:  mapper,
 		ppu:     ppu,
 		cpu:     cpu,
+		apu:     apu,
+		timer:   timer,
 		Running: false,
 
 		config:  config,
 		Buttons: buttons,
+		Clock:   time.Now,
 	}
 
 	ppu.gb = &gb // Ugly cross dependency, so PPU can request interrupts
+	timer.gb = &gb
+	cpu.clock = gb.clock
 
 	// Set up the initial state of the Gameboy
 	mapper.write(LCDC, 0x91) // Set the LCDC register
 	mapper.write(STAT, 0x85) // Set the STAT register
-	mapper.write(LY, 0x00)   // Set the scanline to 145
 	mapper.write(BGP, 0xFC)  // Set the background palette
-	mapper.write(DIV, 0xAB)  // Set the divider
 	mapper.write(TAC, 0xF8)  // Set the timer control
 	mapper.write(TIMA, 0x00) // Set the timer counter
 	mapper.write(TMA, 0x00)  // Set the timer modulo
+	mapper.write(NR52, 0x80) // Switch on the sound
+	mapper.write(NR50, 0x77) // Set master volume to full
+	mapper.write(NR51, 0xF3) // Set the sound panning
 
 	// Optional boot ROM, not needed but included for authenticity
 	if config.BootROM != "" {
 		bootROMFile, err := os.Open(config.BootROM)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
+		defer bootROMFile.Close()
 
-		br, err := io.ReadAll(bootROMFile)
-		if err != nil {
-			log.Fatal(err)
+		if err := gb.LoadBootROMFromReader(bootROMFile); err != nil {
+			return nil, err
 		}
-
-		mapper.loadBootROM(br)
 	}
 
 	if !mapper.bootROMEnabled() {
@@ -87,6 +123,8 @@ func NewGameboy(config Config) *Gameboy {
 		mapper.write(BOOT_ROM_DISABLE, 0x01)
 		// Jump PC to 0x100, this is where PC would be after the boot ROM
 		cpu.pc = 0x100
+		// The divider would have been counting while the boot ROM ran
+		timer.counter = DIV_AFTER_BOOT
 	}
 
 	if len(config.Breakpoints) > 0 {
@@ -101,18 +139,16 @@ func NewGameboy(config Config) *Gameboy {
 		cpu.opDebug = config.OpcodeDebug
 	}
 
-	return &gb
+	return &gb, nil
 }
 
 // Update runs the system each frame
 func (gb *Gameboy) Update(cyclesPerFrame int) {
 	// This is how we step manually
 	if cyclesPerFrame <= 0 {
-		cpuCycles := gb.cpu.ExecuteNext(true)
-		cpuCycles += gb.checkInterrupts()
-
-		// PPU update
-		gb.ppu.cycle(cpuCycles)
+		cycles := gb.cpu.ExecuteNext(true)
+		gb.checkInterrupts()
+		gb.updateSave(cycles)
 
 		return
 	}
@@ -121,23 +157,48 @@ func (gb *Gameboy) Update(cyclesPerFrame int) {
 		return
 	}
 
+	gb.run(cyclesPerFrame)
+}
+
+// RunFrame advances the emulation by exactly one video frame, this doesn't depend on
+// the Running flag or any frontend timing, so it's the way to drive a headless Gameboy
+func (gb *Gameboy) RunFrame() {
+	// Instructions don't line up with the end of a frame, so carry over any extra cycles
+	gb.frameOvershoot = gb.run(CYCLES_PER_FRAME - gb.frameOvershoot)
+}
+
+// Run the CPU and other components for at least the given number of cycles,
+// returns how many cycles past that were run, as it stops on instruction boundaries
+func (gb *Gameboy) run(targetCycles int) int {
 	cycles := 0
-	for cycles <= cyclesPerFrame {
+	for cycles < targetCycles {
+		// Interrupt for joypad
+		if gb.Buttons.Changed() {
+			gb.requestInterrupt(INT_JOYPAD)
+			gb.cpu.stopped = false // This is the only way out of STOP
+			gb.Buttons.ClearChanged()
+		}
+
+		// Nothing runs in STOP, the rest of the frame passes until a button is pressed
+		if gb.cpu.stopped {
+			gb.updateSave(targetCycles - cycles)
+			return 0
+		}
+
 		// Run the CPU fetch/exec cycle
 		cpuCycles := gb.cpu.ExecuteNext(false)
 		if cpuCycles < 0 {
 			log.Println("Stopping emulation")
 			gb.Running = false
-			gb.ppu.render()
-			break
+			return 0
 		}
 
-		// Update core components
-		gb.ppu.cycle(cpuCycles)
-		gb.updateTimers(cpuCycles)
+		// The other components were run by the CPU as it went, see clock
 		cycles += cpuCycles
 		cycles += gb.checkInterrupts()
 
+		gb.updateSave(cpuCycles)
+
 		// Read serial port, really only used for debugging and Blargg's tests
 		if gb.mapper.io[0x02] == 0x81 {
 			fmt.Printf("%c", gb.mapper.io[0x01])
@@ -145,59 +206,38 @@ func (gb *Gameboy) Update(cyclesPerFrame int) {
 		}
 	}
 
-	// Interrupt for joypad
-	if gb.Buttons.Changed() {
-		gb.requestInterrupt(INT_JOYPAD)
-		gb.Buttons.ClearChanged()
-	}
-
-	// HACK: Not sure this needs to be here
-	//gb.ppu.render()
+	return cycles - targetCycles
 }
 
-func (gb *Gameboy) Render() {
-	gb.ppu.render()
+// Run everything apart from the CPU for the given number of cycles, this is called by
+// the CPU on every memory access, so they're kept in step within an instruction
+func (gb *Gameboy) clock(cycles int) {
+	gb.mapper.cycleDMA(cycles)
+	gb.ppu.cycle(cycles)
+	gb.apu.cycle(cycles)
+	gb.timer.cycle(cycles)
 }
 
 func (gb *Gameboy) checkInterrupts() int {
-	// Check for interrupts
-	if gb.mapper.read(IF)&gb.mapper.read(IE) != 0 {
-		gb.cpu.halted = false
+	// Interrupts aren't serviced in STOP, only a button press wakes the CPU
+	if gb.cpu.stopped {
+		return 0
 	}
 
-	if gb.cpu.ime {
-		interruptMask := gb.mapper.read(IF) & gb.mapper.read(IE)
-
-		if interruptMask != 0 {
-			if gb.cpu.halted {
-				log.Printf("Halted, and interrupt %08b requested, pc: 0x%04X", interruptMask, gb.cpu.pc)
-				gb.cpu.halted = false
-			}
-
-			if interruptMask&INT_VBLANK != 0 {
-				gb.cpu.handleInterrupt(INT_VBLANK)
-				return 5
-			}
-			if interruptMask&INT_LCD != 0 {
-				gb.cpu.handleInterrupt(INT_LCD)
-				return 5
-			}
-			if interruptMask&INT_TIMER != 0 {
-				gb.cpu.handleInterrupt(INT_TIMER)
-				return 5
-			}
-			if interruptMask&INT_SERIAL != 0 {
-				gb.cpu.handleInterrupt(INT_SERIAL)
-				return 5
-			}
-			if interruptMask&INT_JOYPAD != 0 {
-				gb.cpu.handleInterrupt(INT_JOYPAD)
-				return 5
-			}
-		}
+	pending := gb.mapper.read(IF) & gb.mapper.read(IE) & INT_MASK
+	if pending == 0 {
+		return 0
+	}
+
+	// Any pending interrupt wakes the CPU from HALT, even with IME off
+	gb.cpu.halted = false
+
+	if !gb.cpu.ime {
+		return 0
 	}
 
-	return 0
+	gb.cpu.handleInterrupt()
+	return INTERRUPT_CYCLES
 }
 
 func (gb *Gameboy) requestInterrupt(interruptBit byte) {
@@ -206,83 +246,177 @@ func (gb *Gameboy) requestInterrupt(interruptBit byte) {
 	gb.mapper.write(IF, interruptByte)
 }
 
-// Timer update is complex
-// TODO: This is still wrong
-func (gb *Gameboy) updateTimers(cycles int) {
-	tac := gb.mapper.read(TAC)
-	if checkBit(tac, 2) {
-		gb.timerCounter += cycles
-
-		// Timer is enabled
-		freq := tac & 0x3
-		var clockSel int
-		switch freq {
-		case 0:
-			clockSel = 256
-		case 1:
-			clockSel = 4
-		case 2:
-			clockSel = 16
-		case 3:
-			clockSel = 64
-		}
+// LoadROM loads a cartridge ROM image from a file, inserting it into the Gameboy
+// The file can be a zip or gzip archive, a specific ROM in a zip can be picked
+// with "archive.zip#game.gb". For carts with a battery the RAM is also loaded
+// from a .sav file named after the ROM or archive
+func (gb *Gameboy) LoadROM(fileName string) error {
+	log.Printf("Loading ROM: %s\n", fileName)
+	fileName, entry := splitROMPath(fileName)
 
-		for gb.timerCounter >= clockSel {
-			gb.timerCounter -= clockSel
-			tima := gb.mapper.read(TIMA)
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
 
-			if tima == 0xff {
-				gb.requestInterrupt(INT_TIMER)
-				gb.mapper.write(TIMA, gb.mapper.read(TMA))
-			} else {
-				gb.mapper.write(TIMA, tima+1)
-			}
-		}
+	rom, err := unpackROM(data, entry)
+	if err != nil {
+		return err
 	}
 
-	gb.divider += cycles
-	if gb.divider >= 255 {
-		gb.divider -= 255
-		// Note that the DIV register is actually at 0xff04
-		// We can't use Write() as that resets the divider
-		gb.mapper.io[4]++
+	if err := gb.loadROM(rom); err != nil {
+		return err
 	}
+
+	if hasBattery(gb.header.CartType) {
+		gb.savePath = SaveBaseName(fileName) + ".sav"
+		gb.loadRAM()
+	}
+
+	return nil
 }
 
-func (gb *Gameboy) LoadROM(fileName string) {
-	log.Printf("Loading ROM: %s\n", fileName)
-	file, err := os.Open(fileName)
+// LoadROMFromReader loads a cartridge ROM image from any reader, see LoadROMBytes
+func (gb *Gameboy) LoadROMFromReader(r io.Reader) error {
+	data, err := io.ReadAll(r)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	fileInfo, _ := file.Stat()
 
-	log.Printf("ROM size: %dKbi\n", fileInfo.Size()/1000)
+	return gb.LoadROMBytes(data)
+}
 
-	// Read the first 16KB of the ROM into the first 16KB of the ROM space
-	_, err = file.Read(gb.mapper.rom0)
+// LoadROMBytes loads a cartridge ROM image held in memory, which can also be a
+// zip or gzip archive, in which case the first .gb or .gbc file is used
+func (gb *Gameboy) LoadROMBytes(data []byte) error {
+	rom, err := unpackROM(data, "")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	// TODO: This needs refactoring once we have MBC and larger ROM support
-	if fileInfo.Size() > 0x4000 {
-		// Read the next 16KB of the ROM into the second 16KB of the ROM space
-		_, err = file.Read(gb.mapper.rom1)
-		if err != nil {
-			log.Fatal(err)
-		}
+	return gb.loadROM(rom)
+}
+
+func (gb *Gameboy) loadROM(rom []byte) error {
+	log.Printf("ROM size: %dKbi\n", len(rom)/1000)
+
+	header, err := ParseHeader(rom)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Cartridge: %s\n", header)
+	if err := header.Validate(); err != nil {
+		log.Printf("Warning, ROM header is not valid: %s\n", strings.ReplaceAll(err.Error(), "\n", ", "))
+	}
+
+	// The MBC in the cartridge handles all ROM banking and external RAM
+	cart, err := newMBC(rom, gb.Clock, gb.rumble)
+	if err != nil {
+		return err
+	}
+
+	gb.mapper.cart = cart
+	gb.header = header
+	gb.savePath = ""
+
+	return nil
+}
+
+// LoadBootROMFromReader loads a boot ROM from any reader, see LoadBootROM
+func (gb *Gameboy) LoadBootROMFromReader(r io.Reader) error {
+	br, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return gb.LoadBootROM(br)
+}
+
+// LoadBootROM enables the given 256 byte boot ROM, and resets the CPU to run it
+func (gb *Gameboy) LoadBootROM(data []byte) error {
+	if err := gb.mapper.loadBootROM(data); err != nil {
+		return err
+	}
+
+	gb.cpu.pc = 0x0000
+
+	return nil
+}
+
+// Header returns the header of the loaded cartridge, or nil if there's no cartridge
+func (gb *Gameboy) Header() *Header {
+	return gb.header
+}
+
+// Passes rumble events from the cartridge on to the frontend, if it's listening
+func (gb *Gameboy) rumble(on bool) {
+	if gb.OnRumble != nil {
+		gb.OnRumble(on)
 	}
 }
 
-func (gb *Gameboy) GetScreen() *ebiten.Image {
+// GetScreen returns the current screen as an RGBA image, in the emulator colours
+func (gb *Gameboy) GetScreen() *image.RGBA {
+	return gb.ppu.screenRGBA()
+}
+
+// GetScreenPaletted returns the current screen as a paletted image, each pixel is the shade 0-3
+func (gb *Gameboy) GetScreenPaletted() *image.Paletted {
+	return gb.ppu.screenPaletted()
+}
+
+// GetFramebuffer returns the raw screen buffer, the shade 0-3 of each pixel, row by row
+func (gb *Gameboy) GetFramebuffer() [SCREEN_WIDTH * SCREEN_HEIGHT]byte {
 	return gb.ppu.screen
 }
 
+// ReadAudio copies generated sound into p, as interleaved left & right 16-bit samples
+// at SAMPLE_RATE, returning how many values were copied. Samples are removed once read
+func (gb *Gameboy) ReadAudio(p []int16) int {
+	return gb.apu.readSamples(p)
+}
+
+// AudioBuffered returns how many sample values are waiting to be read with ReadAudio
+func (gb *Gameboy) AudioBuffered() int {
+	return len(gb.apu.samples)
+}
+
+// SetChannelMuted leaves sound channel 1-4 out of the audio, it still runs as normal
+func (gb *Gameboy) SetChannelMuted(channel int, muted bool) {
+	if channel < 1 || channel > 4 {
+		return
+	}
+
+	gb.apu.muted[channel-1] = muted
+}
+
+// ChannelMuted returns true if sound channel 1-4 has been muted with SetChannelMuted
+func (gb *Gameboy) ChannelMuted(channel int) bool {
+	if channel < 1 || channel > 4 {
+		return false
+	}
+
+	return gb.apu.muted[channel-1]
+}
+
+// GetChannelScope returns the most recent output of sound channel 1-4 before mixing,
+// oldest first, SCOPE_SIZE samples at SAMPLE_RATE each from 0.0 to 1.0
+func (gb *Gameboy) GetChannelScope(channel int) []float32 {
+	if channel < 1 || channel > 4 {
+		return nil
+	}
+
+	return gb.apu.scope(channel - 1)
+}
+
 func (gb *Gameboy) GetDebugInfo() string {
 	cpu := gb.cpu
 
 	out := ""
+	if gb.header != nil {
+		out += fmt.Sprintf("ROM: %s [%s]\n", gb.header.Title, gb.header.CartTypeName())
+	}
 	out += fmt.Sprintf("PC: 0x%04X -> %s\n\n", gb.cpu.pc, opcodeNames[gb.mapper.read(cpu.pc)])
 	out += fmt.Sprintf("A:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X\n",
 		cpu.A(), cpu.B(), cpu.C(), cpu.D(), cpu.E(), cpu.H(), cpu.L())
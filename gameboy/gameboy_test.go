@@ -0,0 +1,22 @@
+package gameboy
+
+import "testing"
+
+// Gameboy with a blank 64KB MBC1 cartridge, running from 0x100 without the boot ROM
+func newTestGameboy(t *testing.T) *Gameboy {
+	t.Helper()
+
+	gb, err := NewGameboy(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rom := make([]byte, 4*ROM_BANK_SIZE)
+	rom[HEADER_CART_TYPE] = CART_MBC1
+	rom[HEADER_ROM_SIZE] = 0x01
+	if err := gb.LoadROMBytes(rom); err != nil {
+		t.Fatal(err)
+	}
+
+	return gb
+}
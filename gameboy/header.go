@@ -0,0 +1,160 @@
+package gameboy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned when loading a ROM image
+var (
+	ErrROMTooSmall     = errors.New("ROM image is too small")
+	ErrROMTooLarge     = errors.New("ROM image is too large")
+	ErrUnsupportedCart = errors.New("unsupported cartridge type")
+)
+
+// Problems found when validating the header, the DMG boot ROM will lock up on the
+// first two, but plenty of homebrew ROMs get them wrong so they are not fatal here
+var (
+	ErrBadLogo        = errors.New("nintendo logo does not match")
+	ErrHeaderChecksum = errors.New("header checksum does not match")
+	ErrGlobalChecksum = errors.New("global checksum does not match")
+)
+
+// Largest ROM supported by any MBC, 8MB for MBC5
+const MAX_ROM_SIZE = 0x800000
+
+// Bitmap of the Nintendo logo, which must be present in the header at 0x104-0x133
+var nintendoLogo = []byte{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0C, 0x00, 0x0D,
+	0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E, 0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99,
+	0xBB, 0xBB, 0x67, 0x63, 0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// Header is the cartridge header at 0x100-0x14F of every ROM
+// https://gbdev.io/pandocs/The_Cartridge_Header.html
+type Header struct {
+	Title            string
+	ManufacturerCode string // Only present on newer carts, otherwise empty
+	CGBFlag          byte   // 0x80 = CGB enhanced, 0xC0 = CGB only
+	SGBFlag          byte   // 0x03 = SGB functions supported
+	Licensee         string // Two character new licensee code, or the old code in hex
+	CartType         byte
+	ROMSize          int  // In bytes
+	RAMSize          int  // In bytes
+	Destination      byte // 0x00 = Japan, 0x01 = Overseas
+	Version          byte
+	HeaderChecksum   byte
+	GlobalChecksum   uint16
+
+	// Results of checking the logo and checksums against the ROM contents
+	LogoValid           bool
+	HeaderChecksumValid bool
+	GlobalChecksumValid bool
+}
+
+// ParseHeader reads the cartridge header from a ROM image
+func ParseHeader(rom []byte) (*Header, error) {
+	if len(rom) < 0x150 {
+		return nil, fmt.Errorf("%w: got %d bytes, need at least 336 for the header", ErrROMTooSmall, len(rom))
+	}
+
+	if len(rom) > MAX_ROM_SIZE {
+		return nil, fmt.Errorf("%w: got %d bytes, maximum is %d", ErrROMTooLarge, len(rom), MAX_ROM_SIZE)
+	}
+
+	h := &Header{
+		CGBFlag:        rom[0x143],
+		SGBFlag:        rom[0x146],
+		CartType:       rom[HEADER_CART_TYPE],
+		ROMSize:        ROM_BANK_SIZE * 2 << rom[HEADER_ROM_SIZE],
+		RAMSize:        headerRAMSize(rom[HEADER_RAM_SIZE]),
+		Destination:    rom[0x14A],
+		Version:        rom[0x14C],
+		HeaderChecksum: rom[0x14D],
+		GlobalChecksum: uint16(rom[0x14E])<<8 | uint16(rom[0x14F]),
+	}
+
+	// On CGB carts the last byte of the title area is the CGB flag
+	title := rom[0x134:0x144]
+	if h.CGBFlag&0x80 != 0 {
+		title = rom[0x134:0x143]
+
+		// Manufacturer code takes the last 4 bytes of the title, when it's there
+		code := string(rom[0x13F:0x143])
+		if isHeaderText(code) {
+			h.ManufacturerCode = code
+			title = rom[0x134:0x13F]
+		}
+	}
+	if end := bytes.IndexByte(title, 0); end >= 0 {
+		title = title[:end]
+	}
+	h.Title = strings.TrimSpace(string(title))
+
+	// Old licensee code 0x33 means the new two character code is used instead
+	if rom[0x14B] == 0x33 {
+		h.Licensee = string(rom[0x144:0x146])
+	} else {
+		h.Licensee = fmt.Sprintf("%02X", rom[0x14B])
+	}
+
+	h.LogoValid = bytes.Equal(rom[0x104:0x134], nintendoLogo)
+
+	checksum := byte(0)
+	for _, b := range rom[0x134:0x14D] {
+		checksum = checksum - b - 1
+	}
+	h.HeaderChecksumValid = checksum == h.HeaderChecksum
+
+	global := uint16(0)
+	for i, b := range rom {
+		if i != 0x14E && i != 0x14F {
+			global += uint16(b)
+		}
+	}
+	h.GlobalChecksumValid = global == h.GlobalChecksum
+
+	return h, nil
+}
+
+// Validate returns all the problems found in the header, or nil if it's good
+func (h *Header) Validate() error {
+	var errs []error
+	if !h.LogoValid {
+		errs = append(errs, ErrBadLogo)
+	}
+	if !h.HeaderChecksumValid {
+		errs = append(errs, ErrHeaderChecksum)
+	}
+	if !h.GlobalChecksumValid {
+		errs = append(errs, ErrGlobalChecksum)
+	}
+
+	return errors.Join(errs...)
+}
+
+// CartTypeName gives a readable name for the cartridge type, e.g. "MBC1+RAM+BATTERY"
+func (h *Header) CartTypeName() string {
+	if name, ok := cartTypeNames[h.CartType]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("UNKNOWN 0x%02X", h.CartType)
+}
+
+func (h *Header) String() string {
+	return fmt.Sprintf("%s [%s] ROM:%dKB RAM:%dKB v%d", h.Title, h.CartTypeName(), h.ROMSize/1024, h.RAMSize/1024, h.Version)
+}
+
+// Header text fields are printable upper case ASCII
+func isHeaderText(s string) bool {
+	for _, c := range s {
+		if c < 0x20 || c > 0x5F {
+			return false
+		}
+	}
+
+	return true
+}
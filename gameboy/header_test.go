@@ -0,0 +1,142 @@
+package gameboy
+
+import (
+	"errors"
+	"testing"
+)
+
+// Makes a 32KB ROM with the logo, a title and both checksums correct
+func headerROM() []byte {
+	rom := testROM(2)
+	copy(rom[0x104:], nintendoLogo)
+	copy(rom[0x134:], "TESTROM")
+	fixChecksums(rom)
+
+	return rom
+}
+
+// Recalculate the header & global checksums after changing a ROM
+func fixChecksums(rom []byte) {
+	checksum := byte(0)
+	for _, b := range rom[0x134:0x14D] {
+		checksum = checksum - b - 1
+	}
+	rom[0x14D] = checksum
+
+	global := uint16(0)
+	for i, b := range rom {
+		if i != 0x14E && i != 0x14F {
+			global += uint16(b)
+		}
+	}
+	rom[0x14E] = byte(global >> 8)
+	rom[0x14F] = byte(global)
+}
+
+func TestParseHeaderSize(t *testing.T) {
+	for _, tc := range []struct {
+		size int
+		want error
+	}{
+		{0, ErrROMTooSmall},
+		{0x14F, ErrROMTooSmall},
+		{0x150, nil},
+		{MAX_ROM_SIZE, nil},
+		{MAX_ROM_SIZE + 1, ErrROMTooLarge},
+	} {
+		_, err := ParseHeader(make([]byte, tc.size))
+		if !errors.Is(err, tc.want) || (tc.want == nil && err != nil) {
+			t.Errorf("%d bytes: got error %v, want %v", tc.size, err, tc.want)
+		}
+	}
+}
+
+func TestHeaderValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(rom []byte)
+		want   []error
+	}{
+		{"valid", func(rom []byte) {}, nil},
+		// Changing the logo & fixing the checksums only leaves the logo wrong
+		{"bad logo", func(rom []byte) {
+			rom[0x104] = 0x00
+			fixChecksums(rom)
+		}, []error{ErrBadLogo}},
+		// The header checksum byte is part of the global checksum, so balance it elsewhere
+		{"header checksum", func(rom []byte) {
+			rom[0x14D]++
+			rom[0x4000]--
+		}, []error{ErrHeaderChecksum}},
+		// The global checksum covers everything but itself
+		{"global checksum", func(rom []byte) { rom[0x4000]++ }, []error{ErrGlobalChecksum}},
+		{"title", func(rom []byte) { rom[0x134] = 'X' }, []error{ErrHeaderChecksum, ErrGlobalChecksum}},
+		{"everything", func(rom []byte) {
+			rom[0x104] = 0x00
+			rom[0x14C] = 0x01
+		}, []error{ErrBadLogo, ErrHeaderChecksum, ErrGlobalChecksum}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rom := headerROM()
+			tc.change(rom)
+
+			h, err := ParseHeader(rom)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = h.Validate()
+			if tc.want == nil && err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			for _, want := range []error{ErrBadLogo, ErrHeaderChecksum, ErrGlobalChecksum} {
+				wanted := false
+				for _, w := range tc.want {
+					wanted = wanted || w == want
+				}
+				if errors.Is(err, want) != wanted {
+					t.Errorf("got error %v, want %v", err, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHeaderFields(t *testing.T) {
+	rom := headerROM()
+	rom[HEADER_CART_TYPE] = CART_MBC1_RAM_BATT
+	rom[HEADER_ROM_SIZE] = 0x01
+	rom[HEADER_RAM_SIZE] = 0x03
+	rom[0x14B] = 0x33
+	copy(rom[0x144:], "01")
+	rom[0x14C] = 0x02
+
+	h, err := ParseHeader(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Title != "TESTROM" || h.CartType != CART_MBC1_RAM_BATT || h.Licensee != "01" || h.Version != 2 {
+		t.Errorf("got title %q type 0x%02X licensee %q version %d", h.Title, h.CartType, h.Licensee, h.Version)
+	}
+	if h.ROMSize != 64*1024 || h.RAMSize != 32*1024 {
+		t.Errorf("got ROM %d RAM %d bytes, want 65536 & 32768", h.ROMSize, h.RAMSize)
+	}
+}
+
+func TestLoadROMUnsupportedCart(t *testing.T) {
+	gb, err := NewGameboy(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rom := headerROM()
+	rom[HEADER_CART_TYPE] = 0xFC
+	if err := gb.LoadROMBytes(rom); !errors.Is(err, ErrUnsupportedCart) {
+		t.Errorf("got error %v, want ErrUnsupportedCart", err)
+	}
+
+	if err := gb.LoadROMBytes(make([]byte, 0x100)); !errors.Is(err, ErrROMTooSmall) {
+		t.Errorf("got error %v, want ErrROMTooSmall", err)
+	}
+}
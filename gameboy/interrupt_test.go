@@ -0,0 +1,81 @@
+package gameboy
+
+import "testing"
+
+func TestInterruptPriority(t *testing.T) {
+	for _, tc := range []struct {
+		ie, iflag byte
+		want      uint16
+		wantIF    byte
+	}{
+		{INT_VBLANK | INT_TIMER, INT_VBLANK | INT_TIMER, 0x40, INT_TIMER},
+		{INT_LCD | INT_JOYPAD, INT_LCD | INT_JOYPAD, 0x48, INT_JOYPAD},
+		{0x1F, INT_TIMER | INT_SERIAL | INT_JOYPAD, 0x50, INT_SERIAL | INT_JOYPAD},
+		{0x1F, INT_SERIAL | INT_JOYPAD, 0x58, INT_JOYPAD},
+		{0x1F, INT_JOYPAD, 0x60, 0},
+		// Requested but not enabled interrupts are skipped, and stay requested
+		{INT_TIMER, INT_VBLANK | INT_LCD | INT_TIMER, 0x50, INT_VBLANK | INT_LCD},
+	} {
+		gb := newTestGameboy(t)
+		gb.cpu.pc = 0x1234
+		gb.cpu.sp = 0xDFF0
+		gb.cpu.ime = true
+		gb.mapper.write(IE, tc.ie)
+		gb.mapper.write(IF, tc.iflag)
+
+		if cycles := gb.checkInterrupts(); cycles != INTERRUPT_CYCLES {
+			t.Errorf("IE 0x%02X IF 0x%02X: took %d cycles, want %d", tc.ie, tc.iflag, cycles, INTERRUPT_CYCLES)
+		}
+		if gb.cpu.pc != tc.want {
+			t.Errorf("IE 0x%02X IF 0x%02X: jumped to 0x%04X, want 0x%04X", tc.ie, tc.iflag, gb.cpu.pc, tc.want)
+		}
+		if got := gb.mapper.read(IF) & INT_MASK; got != tc.wantIF {
+			t.Errorf("IE 0x%02X IF 0x%02X: IF 0x%02X after, want 0x%02X", tc.ie, tc.iflag, got, tc.wantIF)
+		}
+		if gb.cpu.ime {
+			t.Errorf("IE 0x%02X IF 0x%02X: IME still set in the handler", tc.ie, tc.iflag)
+		}
+		if gb.cpu.sp != 0xDFEE || gb.mapper.read(0xDFEF) != 0x12 || gb.mapper.read(0xDFEE) != 0x34 {
+			t.Errorf("IE 0x%02X IF 0x%02X: PC not pushed, SP 0x%04X", tc.ie, tc.iflag, gb.cpu.sp)
+		}
+	}
+}
+
+func TestInterruptPushOverwritesIE(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		pc     uint16
+		iflag  byte
+		want   uint16
+		wantIF byte
+	}{
+		// SP wraps to 0xFFFF, so the upper byte of the PC is pushed to IE. It clears the only
+		// enabled interrupt, dispatch is cancelled and IF is left as it was
+		{"cancelled", 0x0200, INT_VBLANK, 0x0000, INT_VBLANK},
+		// The new IE picks a different interrupt
+		{"redirected", 0x0400, INT_VBLANK | INT_TIMER, 0x0050, INT_VBLANK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gb := newTestGameboy(t)
+			gb.cpu.pc = tc.pc
+			gb.cpu.sp = 0x0000
+			gb.cpu.ime = true
+			gb.mapper.write(IE, INT_VBLANK)
+			gb.mapper.write(IF, tc.iflag)
+
+			gb.checkInterrupts()
+			if gb.cpu.pc != tc.want {
+				t.Errorf("jumped to 0x%04X, want 0x%04X", gb.cpu.pc, tc.want)
+			}
+			if got := gb.mapper.read(IE); got != byte(tc.pc>>8) {
+				t.Errorf("IE 0x%02X, want the pushed 0x%02X", got, byte(tc.pc>>8))
+			}
+			if got := gb.mapper.read(IF) & INT_MASK; got != tc.wantIF {
+				t.Errorf("IF 0x%02X after, want 0x%02X", got, tc.wantIF)
+			}
+			if gb.cpu.sp != 0xFFFE {
+				t.Errorf("SP 0x%04X, want 0xFFFE", gb.cpu.sp)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package gameboy
 
 import (
+	"fmt"
 	"log"
 )
 
@@ -35,6 +36,7 @@ const OBP0 = 0xFF48
 const OBP1 = 0xFF49
 const WY = 0xFF4A
 const WX = 0xFF4B
+const KEY1 = 0xFF4D
 const BOOT_ROM_DISABLE = 0xFF50
 const IE = 0xFFFF
 
@@ -53,10 +55,8 @@ const IE = 0xFFFF
 
 // Mapper is the memory map for the Gameboy
 type Mapper struct {
-	rom0      []byte
-	rom1      []byte
+	cart      MBC
 	vram      []byte
-	extRAM    []byte
 	wram      []byte
 	oam       []byte
 	io        []byte
@@ -65,28 +65,29 @@ type Mapper struct {
 
 	bootROM []byte
 
+	// Set on writes to the cartridge RAM, so battery backed RAM can be saved
+	ramWritten bool
+
+	dma dmaTransfer
+
 	watches []uint16
 	buttons *Buttons
+	apu     *APU
+	timer   *Timer
 }
 
-func NewMapper(buttons *Buttons) *Mapper {
+func NewMapper(buttons *Buttons, apu *APU, timer *Timer) *Mapper {
 	m := &Mapper{
-		rom0:   make([]byte, 0x4000), // 16KB of ROM
-		rom1:   make([]byte, 0x4000), // 16KB of ROM
-		vram:   make([]byte, 0x2000), // 8KB of VRAM
-		extRAM: make([]byte, 0x2000), // 8KB of external RAM
-		wram:   make([]byte, 0x2000), // 8KB of WRAM
-		oam:    make([]byte, 0x100),  // 160 bytes of OAM
-		io:     make([]byte, 0x80),   // 128 bytes of IO
-		hram:   make([]byte, 0x7F),   // 127 bytes of HRAM
+		vram: make([]byte, 0x2000), // 8KB of VRAM
+		wram: make([]byte, 0x2000), // 8KB of WRAM
+		oam:  make([]byte, 0x100),  // 160 bytes of OAM
+		io:   make([]byte, 0x80),   // 128 bytes of IO
+		hram: make([]byte, 0x7F),   // 127 bytes of HRAM
 
 		watches: []uint16{},
 		buttons: buttons,
-	}
-
-	// Fill rom0 with 0xFF, really just to simulate having no cartridge inserted
-	for i := 0; i < 0x4000; i++ {
-		m.rom0[i] = 0xFF
+		apu:     apu,
+		timer:   timer,
 	}
 
 	return m
@@ -94,15 +95,12 @@ func NewMapper(buttons *Buttons) *Mapper {
 
 func (m *Mapper) write(addr uint16, data byte) {
 	switch {
-	case addr < ROM_BANK:
+	case addr < VRAM:
 		{
-			// Ignore writes to the ROM bank 0
-			//m.rom0[addr] = data
-		}
-	case addr >= ROM_BANK && addr < VRAM:
-		{
-			// Ignore writes to the ROM bank 1
-			//m.rom1[addr-ROM_BANK] = data
+			// Writes to the ROM area control the MBC in the cartridge
+			if m.cart != nil {
+				m.cart.write(addr, data)
+			}
 		}
 
 	case addr >= VRAM && addr < EXT_RAM:
@@ -117,7 +115,9 @@ func (m *Mapper) write(addr uint16, data byte) {
 
 	case addr >= EXT_RAM && addr < WRAM:
 		{
-			m.extRAM[addr-EXT_RAM] = data
+			if m.cart != nil && m.cart.write(addr, data) {
+				m.ramWritten = true
+			}
 		}
 
 	case addr >= WRAM && addr < ECHO_RAM:
@@ -145,18 +145,31 @@ func (m *Mapper) write(addr uint16, data byte) {
 
 	case addr >= IO && addr < HRAM:
 		{
-			if addr == DIV {
-				// Writing to the DIV register resets the counter
-				m.io[addr-IO] = 0
+			if addr >= DIV && addr <= TAC {
+				m.timer.write(addr, data)
+				return
+			}
+
+			if addr >= NR10 && addr < SOUND_END {
+				m.apu.write(addr, data)
+				return
+			}
+
+			if addr == STAT {
+				// Lower 3 bits are the mode & coincidence flag, which are read only
+				m.io[addr-IO] = data&0x78 | m.io[addr-IO]&0x07
+				return
+			}
+
+			if addr == LY {
+				// LY is read only, it's updated by the PPU
 				return
 			}
 
 			if addr == DMA {
+				// The data is the upper byte of the source address, the copy is run by cycleDMA
 				m.io[addr-IO] = data
-				for i := 0; i < 0xA0; i++ {
-					// For DMA source address is divided by 0x100 for some reason
-					m.write(OAM+uint16(i), m.read(uint16(data)*0x100+uint16(i)))
-				}
+				m.startDMA(data)
 				return
 			}
 
@@ -185,7 +198,8 @@ func (m *Mapper) write(addr uint16, data byte) {
 
 	default:
 		{
-			log.Fatalf("Invalid memory write at %04X", addr)
+			// Should be impossible, every address is covered above
+			log.Printf("Invalid memory write at %04X", addr)
 		}
 	}
 }
@@ -198,13 +212,13 @@ func (m Mapper) read(addr uint16) byte {
 			return m.bootROM[addr]
 		}
 
-		return m.rom0[addr]
+		return m.readCart(addr)
 	case addr >= ROM_BANK && addr < VRAM:
-		return m.rom1[addr-ROM_BANK]
+		return m.readCart(addr)
 	case addr >= VRAM && addr < EXT_RAM:
 		return m.vram[addr-VRAM]
 	case addr >= EXT_RAM && addr < WRAM:
-		return m.extRAM[addr-EXT_RAM]
+		return m.readCart(addr)
 	case addr >= WRAM && addr < ECHO_RAM:
 		return m.wram[addr-WRAM]
 	case addr >= ECHO_RAM && addr < OAM:
@@ -231,9 +245,29 @@ func (m Mapper) read(addr uint16) byte {
 			return m.io[0] | 0x0F
 		}
 
-		// if addr == STAT {
-		// 	log.Printf("Reading STAT register\n")
-		// }
+		if addr >= DIV && addr <= TAC {
+			return m.timer.read(addr)
+		}
+
+		if addr >= NR10 && addr < SOUND_END {
+			return m.apu.read(addr)
+		}
+
+		// KEY1 is the CGB speed switch that STOP toggles, there's no register there on
+		// DMG so it reads 0xFF and games don't wait on a switch that never happens
+		if addr == KEY1 {
+			return 0xFF
+		}
+
+		// Unused top 3 bits of IF always read as 1
+		if addr == IF {
+			return m.io[addr-IO] | 0xE0
+		}
+
+		// Unused top bit of STAT always reads as 1
+		if addr == STAT {
+			return m.io[addr-IO] | 0x80
+		}
 
 		// if addr == LCDC {
 		// 	log.Printf("Reading LCDC register\n")
@@ -253,21 +287,33 @@ func (m Mapper) read(addr uint16) byte {
 
 	}
 
-	log.Fatalf("Invalid memory read at %04X", addr)
+	// Should be impossible, every address is covered above
+	log.Printf("Invalid memory read at %04X", addr)
+
+	return 0xFF
+}
+
+// Reads from the cartridge, with no cartridge inserted the bus floats high
+func (m Mapper) readCart(addr uint16) byte {
+	if m.cart == nil {
+		return 0xFF
+	}
 
-	return 0
+	return m.cart.read(addr)
 }
 
 func (m *Mapper) bootROMEnabled() bool {
 	return len(m.bootROM) > 0 && m.read(BOOT_ROM_DISABLE) == 0
 }
 
-func (m *Mapper) loadBootROM(data []byte) {
+func (m *Mapper) loadBootROM(data []byte) error {
 	log.Printf("Configuring boot ROM")
 	if len(data) != 0x100 {
-		log.Fatalf("Boot ROM is not the correct size, got %d bytes, expected 256 bytes", len(data))
+		return fmt.Errorf("boot ROM is not the correct size, got %d bytes, expected 256 bytes", len(data))
 	}
 
 	m.write(BOOT_ROM_DISABLE, 0x00) // ENABLE the boot ROM
 	m.bootROM = data
+
+	return nil
 }
@@ -0,0 +1,14 @@
+package gameboy
+
+import "testing"
+
+func TestKEY1ReadsAsUnmapped(t *testing.T) {
+	gb := newTestGameboy(t)
+
+	for _, data := range []byte{0x00, 0x01} {
+		gb.mapper.write(KEY1, data)
+		if got := gb.mapper.read(KEY1); got != 0xFF {
+			t.Errorf("KEY1 after writing 0x%02X: read 0x%02X, want 0xFF", data, got)
+		}
+	}
+}
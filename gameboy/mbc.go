@@ -0,0 +1,159 @@
+package gameboy
+
+import (
+	"fmt"
+	"time"
+)
+
+// Cartridge types, from the header byte at 0x147
+// https://gbdev.io/pandocs/The_Cartridge_Header.html#0147--cartridge-type
+const CART_ROM_ONLY = 0x00
+const CART_MBC1 = 0x01
+const CART_MBC1_RAM = 0x02
+const CART_MBC1_RAM_BATT = 0x03
+const CART_MBC2 = 0x05
+const CART_MBC2_BATT = 0x06
+const CART_ROM_RAM = 0x08
+const CART_ROM_RAM_BATT = 0x09
+const CART_MBC3_TIMER_BATT = 0x0F
+const CART_MBC3_TIMER_RAM_BATT = 0x10
+const CART_MBC3 = 0x11
+const CART_MBC3_RAM = 0x12
+const CART_MBC3_RAM_BATT = 0x13
+const CART_MBC5 = 0x19
+const CART_MBC5_RAM = 0x1A
+const CART_MBC5_RAM_BATT = 0x1B
+const CART_MBC5_RUMBLE = 0x1C
+const CART_MBC5_RUMBLE_RAM = 0x1D
+const CART_MBC5_RUMBLE_RAM_BATT = 0x1E
+
+var cartTypeNames = map[byte]string{
+	CART_ROM_ONLY:             "ROM ONLY",
+	CART_MBC1:                 "MBC1",
+	CART_MBC1_RAM:             "MBC1+RAM",
+	CART_MBC1_RAM_BATT:        "MBC1+RAM+BATTERY",
+	CART_MBC2:                 "MBC2",
+	CART_MBC2_BATT:            "MBC2+BATTERY",
+	CART_ROM_RAM:              "ROM+RAM",
+	CART_ROM_RAM_BATT:         "ROM+RAM+BATTERY",
+	CART_MBC3_TIMER_BATT:      "MBC3+TIMER+BATTERY",
+	CART_MBC3_TIMER_RAM_BATT:  "MBC3+TIMER+RAM+BATTERY",
+	CART_MBC3:                 "MBC3",
+	CART_MBC3_RAM:             "MBC3+RAM",
+	CART_MBC3_RAM_BATT:        "MBC3+RAM+BATTERY",
+	CART_MBC5:                 "MBC5",
+	CART_MBC5_RAM:             "MBC5+RAM",
+	CART_MBC5_RAM_BATT:        "MBC5+RAM+BATTERY",
+	CART_MBC5_RUMBLE:          "MBC5+RUMBLE",
+	CART_MBC5_RUMBLE_RAM:      "MBC5+RUMBLE+RAM",
+	CART_MBC5_RUMBLE_RAM_BATT: "MBC5+RUMBLE+RAM+BATTERY",
+}
+
+// Header offsets
+const HEADER_CART_TYPE = 0x147
+const HEADER_ROM_SIZE = 0x148
+const HEADER_RAM_SIZE = 0x149
+
+const ROM_BANK_SIZE = 0x4000
+const RAM_BANK_SIZE = 0x2000
+
+// MBC is the memory bank controller inside the cartridge, it handles reads & writes
+// to both the ROM area (0x0000-0x7FFF) and the external RAM area (0xA000-0xBFFF)
+// Writes to the ROM area are used to control the banking registers
+type MBC interface {
+	read(addr uint16) byte
+
+	// Returns true if the data was stored in the cartridge RAM, so it can be saved
+	write(addr uint16, data byte) (stored bool)
+}
+
+// Create the correct MBC for the given ROM image, based on the cartridge type in the header
+// The ROM must have already been checked with ParseHeader
+// The clock is only used by carts with a real time clock, and rumble by carts with a motor
+func newMBC(rom []byte, clock func() time.Time, rumble func(on bool)) (MBC, error) {
+	// Pad the ROM out to a whole number of banks, and at least two of them
+	if len(rom)%ROM_BANK_SIZE != 0 || len(rom) < ROM_BANK_SIZE*2 {
+		size := max(ROM_BANK_SIZE*2, (len(rom)+ROM_BANK_SIZE-1)/ROM_BANK_SIZE*ROM_BANK_SIZE)
+		padded := make([]byte, size)
+		for i := range padded {
+			padded[i] = 0xFF
+		}
+		copy(padded, rom)
+		rom = padded
+	}
+
+	ramSize := headerRAMSize(rom[HEADER_RAM_SIZE])
+
+	switch rom[HEADER_CART_TYPE] {
+	case CART_ROM_ONLY, CART_ROM_RAM, CART_ROM_RAM_BATT:
+		return newROMOnly(rom, ramSize), nil
+	case CART_MBC1, CART_MBC1_RAM, CART_MBC1_RAM_BATT:
+		return newMBC1(rom, ramSize), nil
+	case CART_MBC2, CART_MBC2_BATT:
+		// MBC2 has its own RAM built in, so the RAM size in the header is ignored
+		return newMBC2(rom), nil
+	case CART_MBC3, CART_MBC3_RAM, CART_MBC3_RAM_BATT:
+		return newMBC3(rom, ramSize, false, clock), nil
+	case CART_MBC3_TIMER_BATT, CART_MBC3_TIMER_RAM_BATT:
+		return newMBC3(rom, ramSize, true, clock), nil
+	case CART_MBC5, CART_MBC5_RAM, CART_MBC5_RAM_BATT:
+		return newMBC5(rom, ramSize, false, rumble), nil
+	case CART_MBC5_RUMBLE, CART_MBC5_RUMBLE_RAM, CART_MBC5_RUMBLE_RAM_BATT:
+		return newMBC5(rom, ramSize, true, rumble), nil
+	}
+
+	return nil, fmt.Errorf("%w 0x%02X", ErrUnsupportedCart, rom[HEADER_CART_TYPE])
+}
+
+// Decode the RAM size byte in the header into a number of bytes
+func headerRAMSize(code byte) int {
+	switch code {
+	case 0x01:
+		return 0x800 // Unofficial 2KB, used by some homebrew
+	case 0x02:
+		return 0x2000
+	case 0x03:
+		return 0x8000
+	case 0x04:
+		return 0x20000
+	case 0x05:
+		return 0x10000
+	}
+
+	return 0
+}
+
+// ROM only cartridge with no banking, 32KB of ROM and optionally 8KB of RAM
+type romOnly struct {
+	rom []byte
+	ram []byte
+}
+
+func newROMOnly(rom []byte, ramSize int) *romOnly {
+	return &romOnly{
+		rom: rom,
+		ram: make([]byte, ramSize),
+	}
+}
+
+func (c *romOnly) read(addr uint16) byte {
+	if addr < VRAM {
+		return c.rom[addr]
+	}
+
+	if int(addr-EXT_RAM) < len(c.ram) {
+		return c.ram[addr-EXT_RAM]
+	}
+
+	return 0xFF
+}
+
+func (c *romOnly) write(addr uint16, data byte) (stored bool) {
+	// Writes to the ROM are ignored, there's no MBC to control
+	if addr >= EXT_RAM && int(addr-EXT_RAM) < len(c.ram) {
+		c.ram[addr-EXT_RAM] = data
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,91 @@
+package gameboy
+
+// MBC1 supports up to 2MB of ROM and 32KB of RAM
+// https://gbdev.io/pandocs/MBC1.html
+type mbc1 struct {
+	rom []byte
+	ram []byte
+
+	ramEnabled bool
+	romBank    byte // 5 bit ROM bank register (0x2000-0x3FFF)
+	bank2      byte // 2 bit upper ROM bank or RAM bank register (0x4000-0x5FFF)
+	mode       byte // Banking mode select (0x6000-0x7FFF)
+}
+
+func newMBC1(rom []byte, ramSize int) *mbc1 {
+	return &mbc1{
+		rom:     rom,
+		ram:     make([]byte, ramSize),
+		romBank: 1,
+	}
+}
+
+func (c *mbc1) read(addr uint16) byte {
+	switch {
+	case addr < ROM_BANK:
+		// In mode 1 the upper bank bits also apply to the first ROM area, only
+		// large carts (1MB+) have enough banks to notice this
+		bank := 0
+		if c.mode == 1 {
+			bank = int(c.bank2) << 5
+		}
+		return c.rom[c.romOffset(bank)+int(addr)]
+
+	case addr < VRAM:
+		bank := int(c.bank2)<<5 | int(c.romBank)
+		return c.rom[c.romOffset(bank)+int(addr-ROM_BANK)]
+
+	case addr >= EXT_RAM && addr < WRAM:
+		if !c.ramEnabled || len(c.ram) == 0 {
+			return 0xFF
+		}
+		return c.ram[c.ramOffset(addr)]
+	}
+
+	return 0xFF
+}
+
+func (c *mbc1) write(addr uint16, data byte) (stored bool) {
+	switch {
+	case addr < 0x2000:
+		// Any value with 0xA in the lower 4 bits enables the RAM
+		c.ramEnabled = data&0x0F == 0x0A
+
+	case addr < 0x4000:
+		c.romBank = data & 0x1F
+		// Bank 0 can't be selected here, it's treated as bank 1. This check is done
+		// on the 5 bit value, which is why banks 0x20, 0x40 & 0x60 are unreachable
+		if c.romBank == 0 {
+			c.romBank = 1
+		}
+
+	case addr < 0x6000:
+		c.bank2 = data & 0x03
+
+	case addr < VRAM:
+		c.mode = data & 0x01
+
+	case addr >= EXT_RAM && addr < WRAM:
+		if c.ramEnabled && len(c.ram) > 0 {
+			c.ram[c.ramOffset(addr)] = data
+			stored = true
+		}
+	}
+
+	return stored
+}
+
+// Offset into the ROM for the given bank, wrapping to the number of banks present
+func (c *mbc1) romOffset(bank int) int {
+	return (bank % (len(c.rom) / ROM_BANK_SIZE)) * ROM_BANK_SIZE
+}
+
+// Offset into the RAM for the given address, in mode 1 the bank2 register selects the RAM bank
+func (c *mbc1) ramOffset(addr uint16) int {
+	bank := 0
+	if c.mode == 1 {
+		bank = int(c.bank2)
+	}
+
+	return (bank*RAM_BANK_SIZE + int(addr-EXT_RAM)) % len(c.ram)
+}
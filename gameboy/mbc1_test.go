@@ -0,0 +1,93 @@
+package gameboy
+
+import "testing"
+
+// Makes a ROM with the given number of banks, each starting with its bank number
+func testROM(banks int) []byte {
+	rom := make([]byte, banks*ROM_BANK_SIZE)
+	for b := 0; b < banks; b++ {
+		rom[b*ROM_BANK_SIZE] = byte(b)
+	}
+
+	return rom
+}
+
+func TestMBC1BankZeroSelectsBankOne(t *testing.T) {
+	c := newMBC1(testROM(4), 0)
+
+	for _, data := range []byte{0x00, 0x20, 0xE0} {
+		c.write(0x2000, data)
+		if got := c.read(ROM_BANK); got != 1 {
+			t.Errorf("ROM bank 0x%02X: read bank %d, want 1", data, got)
+		}
+	}
+
+	c.write(0x2000, 0x03)
+	if got := c.read(ROM_BANK); got != 3 {
+		t.Errorf("ROM bank 0x03: read bank %d, want 3", got)
+	}
+}
+
+func TestMBC1BanksAliasPastBankZero(t *testing.T) {
+	// 2MB, so every bank is present and the upper bits in bank2 matter
+	c := newMBC1(testROM(128), 0)
+
+	for _, tc := range []struct {
+		bank2 byte
+		want  byte
+	}{
+		{0, 0x01},
+		{1, 0x21},
+		{2, 0x41},
+		{3, 0x61},
+	} {
+		c.write(0x2000, 0x00)
+		c.write(0x4000, tc.bank2)
+		if got := c.read(ROM_BANK); got != tc.want {
+			t.Errorf("bank2 %d: read bank 0x%02X, want 0x%02X", tc.bank2, got, tc.want)
+		}
+	}
+}
+
+func TestMBC1Mode1UpperBitsApplyToBankZero(t *testing.T) {
+	c := newMBC1(testROM(128), 0)
+	c.write(0x4000, 0x01)
+
+	if got := c.read(0x0000); got != 0x00 {
+		t.Errorf("mode 0: read bank 0x%02X at 0x0000, want 0x00", got)
+	}
+
+	c.write(0x6000, 0x01)
+	if got := c.read(0x0000); got != 0x20 {
+		t.Errorf("mode 1: read bank 0x%02X at 0x0000, want 0x20", got)
+	}
+}
+
+func TestMBC1Mode1RAMBanking(t *testing.T) {
+	c := newMBC1(testROM(4), 4*RAM_BANK_SIZE)
+	c.write(0x0000, 0x0A)
+	c.write(0x6000, 0x01)
+
+	for bank := byte(0); bank < 4; bank++ {
+		c.write(0x4000, bank)
+		c.write(EXT_RAM, 0x10+bank)
+	}
+
+	for bank := byte(0); bank < 4; bank++ {
+		c.write(0x4000, bank)
+		if got := c.read(EXT_RAM); got != 0x10+bank {
+			t.Errorf("RAM bank %d: read 0x%02X, want 0x%02X", bank, got, 0x10+bank)
+		}
+	}
+
+	// Mode 0 only ever uses the first RAM bank
+	c.write(0x6000, 0x00)
+	if got := c.read(EXT_RAM); got != 0x10 {
+		t.Errorf("mode 0: read 0x%02X, want 0x10", got)
+	}
+
+	c.write(0x0000, 0x00)
+	if got := c.read(EXT_RAM); got != 0xFF {
+		t.Errorf("RAM disabled: read 0x%02X, want 0xFF", got)
+	}
+}
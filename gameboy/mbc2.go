@@ -0,0 +1,62 @@
+package gameboy
+
+// MBC2 supports up to 256KB of ROM and has 512 x 4 bits of RAM built in
+// https://gbdev.io/pandocs/MBC2.html
+type mbc2 struct {
+	rom []byte
+	ram []byte // Only the lower 4 bits of each byte are used
+
+	ramEnabled bool
+	romBank    byte // 4 bit ROM bank register
+}
+
+func newMBC2(rom []byte) *mbc2 {
+	return &mbc2{
+		rom:     rom,
+		ram:     make([]byte, 0x200),
+		romBank: 1,
+	}
+}
+
+func (c *mbc2) read(addr uint16) byte {
+	switch {
+	case addr < ROM_BANK:
+		return c.rom[addr]
+
+	case addr < VRAM:
+		offset := (int(c.romBank) % (len(c.rom) / ROM_BANK_SIZE)) * ROM_BANK_SIZE
+		return c.rom[offset+int(addr-ROM_BANK)]
+
+	case addr >= EXT_RAM && addr < WRAM:
+		if !c.ramEnabled {
+			return 0xFF
+		}
+		// The 512 half bytes are mirrored across the whole area, upper bits are open bus
+		return c.ram[addr&0x1FF] | 0xF0
+	}
+
+	return 0xFF
+}
+
+func (c *mbc2) write(addr uint16, data byte) (stored bool) {
+	switch {
+	case addr < ROM_BANK:
+		// Bit 8 of the address selects between the RAM enable and ROM bank registers
+		if addr&0x100 == 0 {
+			c.ramEnabled = data&0x0F == 0x0A
+		} else {
+			c.romBank = data & 0x0F
+			if c.romBank == 0 {
+				c.romBank = 1
+			}
+		}
+
+	case addr >= EXT_RAM && addr < WRAM:
+		if c.ramEnabled {
+			c.ram[addr&0x1FF] = data & 0x0F
+			stored = true
+		}
+	}
+
+	return stored
+}
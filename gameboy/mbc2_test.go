@@ -0,0 +1,73 @@
+package gameboy
+
+import "testing"
+
+func TestMBC2RAM(t *testing.T) {
+	c := newMBC2(testROM(4))
+	c.write(0x0000, 0x0A)
+
+	// Only the lower 4 bits are stored, the upper ones read as 1
+	c.write(EXT_RAM, 0x5C)
+	if got := c.read(EXT_RAM); got != 0xFC {
+		t.Errorf("read 0x%02X after writing 0x5C, want 0xFC", got)
+	}
+
+	// The 512 half bytes repeat through the whole RAM area
+	for _, addr := range []uint16{0xA200, 0xA400, 0xBE00} {
+		if got := c.read(addr); got != 0xFC {
+			t.Errorf("read 0x%02X at 0x%04X, want the mirror of 0xA000", got, addr)
+		}
+	}
+	c.write(0xBFFF, 0x03)
+	if got := c.read(EXT_RAM + 0x1FF); got != 0xF3 {
+		t.Errorf("read 0x%02X at 0xA1FF, want 0xF3 written to its mirror", got)
+	}
+
+	c.write(0x0000, 0x00)
+	if got := c.read(EXT_RAM); got != 0xFF {
+		t.Errorf("RAM disabled: read 0x%02X, want 0xFF", got)
+	}
+	if c.write(EXT_RAM, 0x01) {
+		t.Error("write with RAM disabled was stored")
+	}
+}
+
+func TestMBC2RegisterSelect(t *testing.T) {
+	c := newMBC2(testROM(16))
+
+	// Address bit 8 clear is the RAM enable, the ROM bank doesn't change
+	c.write(0x0000, 0x0A)
+	c.write(EXT_RAM, 0x01)
+	if got := c.read(EXT_RAM); got != 0xF1 {
+		t.Errorf("read 0x%02X after enabling RAM at 0x0000, want 0xF1", got)
+	}
+	if got := c.read(ROM_BANK); got != 1 {
+		t.Errorf("ROM bank %d after writing 0x0A at 0x0000, want 1", got)
+	}
+
+	// Address bit 8 set is the ROM bank, the RAM stays enabled
+	for _, addr := range []uint16{0x0100, 0x2100, 0x3FFF} {
+		c.write(addr, 0x05)
+		if got := c.read(ROM_BANK); got != 5 {
+			t.Errorf("ROM bank %d after writing 5 at 0x%04X, want 5", got, addr)
+		}
+		c.write(addr, 0x02)
+	}
+	if got := c.read(EXT_RAM); got != 0xF1 {
+		t.Errorf("read 0x%02X after selecting ROM banks, want the RAM still enabled", got)
+	}
+
+	// Only 4 bits of the bank are used, and bank 0 selects bank 1
+	for _, tc := range []struct {
+		data, want byte
+	}{
+		{0x00, 1},
+		{0x10, 1},
+		{0x1F, 15},
+	} {
+		c.write(0x2100, tc.data)
+		if got := c.read(ROM_BANK); got != tc.want {
+			t.Errorf("ROM bank 0x%02X: read bank %d, want %d", tc.data, got, tc.want)
+		}
+	}
+}
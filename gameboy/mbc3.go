@@ -0,0 +1,212 @@
+package gameboy
+
+import (
+	"time"
+)
+
+// MBC3 supports up to 2MB of ROM, 32KB of RAM and an optional real time clock (RTC)
+// https://gbdev.io/pandocs/MBC3.html
+type mbc3 struct {
+	rom []byte
+	ram []byte
+
+	ramEnabled bool // Also enables access to the RTC registers
+	romBank    byte // 7 bit ROM bank register (0x2000-0x3FFF)
+	bank       byte // RAM bank 0x00-0x03 or RTC register 0x08-0x0C (0x4000-0x5FFF)
+	latchWrite byte // Last value written to the latch register (0x6000-0x7FFF)
+
+	rtc     rtc
+	latched rtc // Snapshot of the clock, this is what the CPU reads
+	hasRTC  bool
+
+	// Source of wall time, and when the clock was last brought up to date
+	now      func() time.Time
+	lastTick time.Time
+}
+
+// RTC registers, the day counter is 9 bits split across DL and DH
+type rtc struct {
+	seconds byte
+	minutes byte
+	hours   byte
+	days    uint16
+	halt    bool
+	carry   bool // Set when the day counter overflows, stays set until cleared
+}
+
+// RTC register select values
+const RTC_S = 0x08
+const RTC_M = 0x09
+const RTC_H = 0x0A
+const RTC_DL = 0x0B
+const RTC_DH = 0x0C
+
+func newMBC3(rom []byte, ramSize int, hasRTC bool, clock func() time.Time) *mbc3 {
+	return &mbc3{
+		rom:      rom,
+		ram:      make([]byte, ramSize),
+		romBank:  1,
+		hasRTC:   hasRTC,
+		now:      clock,
+		lastTick: clock(),
+	}
+}
+
+func (c *mbc3) read(addr uint16) byte {
+	switch {
+	case addr < ROM_BANK:
+		return c.rom[addr]
+
+	case addr < VRAM:
+		return c.rom[c.romOffset()+int(addr-ROM_BANK)]
+
+	case addr >= EXT_RAM && addr < WRAM:
+		if !c.ramEnabled {
+			return 0xFF
+		}
+
+		if c.bank >= RTC_S && c.bank <= RTC_DH {
+			if !c.hasRTC {
+				return 0xFF
+			}
+			return c.latched.readReg(c.bank)
+		}
+
+		if len(c.ram) == 0 {
+			return 0xFF
+		}
+		return c.ram[c.ramOffset(addr)]
+	}
+
+	return 0xFF
+}
+
+func (c *mbc3) write(addr uint16, data byte) (stored bool) {
+	switch {
+	case addr < 0x2000:
+		c.ramEnabled = data&0x0F == 0x0A
+
+	case addr < 0x4000:
+		c.romBank = data & 0x7F
+		if c.romBank == 0 {
+			c.romBank = 1
+		}
+
+	case addr < 0x6000:
+		c.bank = data & 0x0F
+
+	case addr < VRAM:
+		// Writing 0x00 then 0x01 latches the current time into the RTC registers
+		if c.latchWrite == 0x00 && data == 0x01 && c.hasRTC {
+			c.tick()
+			c.latched = c.rtc
+		}
+		c.latchWrite = data
+
+	case addr >= EXT_RAM && addr < WRAM:
+		if !c.ramEnabled {
+			return
+		}
+
+		if c.bank >= RTC_S && c.bank <= RTC_DH {
+			if c.hasRTC {
+				// Bring the clock up to date first, so time passed before the write isn't lost
+				c.tick()
+				c.rtc.writeReg(c.bank, data)
+				c.latched.writeReg(c.bank, data)
+			}
+			return
+		}
+
+		if len(c.ram) > 0 {
+			c.ram[c.ramOffset(addr)] = data
+			stored = true
+		}
+	}
+
+	return stored
+}
+
+func (c *mbc3) romOffset() int {
+	return (int(c.romBank) % (len(c.rom) / ROM_BANK_SIZE)) * ROM_BANK_SIZE
+}
+
+func (c *mbc3) ramOffset(addr uint16) int {
+	return (int(c.bank&0x03)*RAM_BANK_SIZE + int(addr-EXT_RAM)) % len(c.ram)
+}
+
+// Advance the RTC by the wall time that has passed since the last tick
+func (c *mbc3) tick() {
+	now := c.now()
+	elapsed := int64(now.Sub(c.lastTick) / time.Second)
+	if elapsed <= 0 {
+		return
+	}
+
+	// Only whole seconds are consumed, so fractions carry over to the next tick
+	c.lastTick = c.lastTick.Add(time.Duration(elapsed) * time.Second)
+
+	if !c.rtc.halt {
+		c.rtc.advance(elapsed)
+	}
+}
+
+// Move the clock forward by the given number of seconds, with carry into the day counter
+func (r *rtc) advance(seconds int64) {
+	total := int64(r.seconds) + seconds
+	r.seconds = byte(total % 60)
+
+	total = int64(r.minutes) + total/60
+	r.minutes = byte(total % 60)
+
+	total = int64(r.hours) + total/60
+	r.hours = byte(total % 24)
+
+	total = int64(r.days) + total/24
+	if total > 0x1FF {
+		r.carry = true
+	}
+	r.days = uint16(total % 0x200)
+}
+
+func (r *rtc) readReg(reg byte) byte {
+	switch reg {
+	case RTC_S:
+		return r.seconds
+	case RTC_M:
+		return r.minutes
+	case RTC_H:
+		return r.hours
+	case RTC_DL:
+		return byte(r.days)
+	case RTC_DH:
+		// Bit 0 is bit 8 of the day counter, bit 6 is halt, bit 7 is day carry
+		dh := byte(r.days>>8) & 0x01
+		if r.halt {
+			dh = bitSet(dh, 6)
+		}
+		if r.carry {
+			dh = bitSet(dh, 7)
+		}
+		return dh
+	}
+
+	return 0xFF
+}
+
+func (r *rtc) writeReg(reg byte, data byte) {
+	switch reg {
+	case RTC_S:
+		r.seconds = data & 0x3F
+	case RTC_M:
+		r.minutes = data & 0x3F
+	case RTC_H:
+		r.hours = data & 0x1F
+	case RTC_DL:
+		r.days = r.days&0x100 | uint16(data)
+	case RTC_DH:
+		r.days = r.days&0xFF | uint16(data&0x01)<<8
+		r.halt = checkBit(data, 6)
+		r.carry = checkBit(data, 7)
+	}
+}
@@ -0,0 +1,117 @@
+package gameboy
+
+import (
+	"testing"
+	"time"
+)
+
+// Clock that only moves when the test says so
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) advance(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+func newTestMBC3() (*mbc3, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := newMBC3(testROM(4), RAM_BANK_SIZE, true, clock.Now)
+	c.write(0x0000, 0x0A)
+
+	return c, clock
+}
+
+// Latch the clock and read one of the RTC registers
+func readRTC(c *mbc3, reg byte) byte {
+	c.write(0x6000, 0x00)
+	c.write(0x6000, 0x01)
+	c.write(0x4000, reg)
+
+	return c.read(EXT_RAM)
+}
+
+func TestMBC3RTCLatch(t *testing.T) {
+	c, clock := newTestMBC3()
+
+	clock.advance(5 * time.Second)
+	if got := readRTC(c, RTC_S); got != 5 {
+		t.Fatalf("seconds after latching: %d, want 5", got)
+	}
+
+	// The latched value stays put until the next 0 then 1 write
+	clock.advance(3 * time.Second)
+	if got := c.read(EXT_RAM); got != 5 {
+		t.Errorf("seconds without latching: %d, want 5", got)
+	}
+
+	c.write(0x6000, 0x01)
+	if got := c.read(EXT_RAM); got != 5 {
+		t.Errorf("seconds after writing 1 twice: %d, want 5", got)
+	}
+
+	if got := readRTC(c, RTC_S); got != 8 {
+		t.Errorf("seconds after latching again: %d, want 8", got)
+	}
+}
+
+func TestMBC3RTCHalt(t *testing.T) {
+	c, clock := newTestMBC3()
+
+	clock.advance(10 * time.Second)
+	c.write(0x4000, RTC_DH)
+	c.write(EXT_RAM, 0x40)
+
+	clock.advance(time.Hour)
+	if got := readRTC(c, RTC_S); got != 10 {
+		t.Errorf("seconds while halted: %d, want 10", got)
+	}
+	if got := readRTC(c, RTC_DH); got&0x40 == 0 {
+		t.Errorf("DH 0x%02X, want the halt bit set", got)
+	}
+
+	c.write(0x4000, RTC_DH)
+	c.write(EXT_RAM, 0x00)
+
+	clock.advance(2 * time.Second)
+	if got := readRTC(c, RTC_S); got != 12 {
+		t.Errorf("seconds after resuming: %d, want 12", got)
+	}
+}
+
+func TestMBC3RTCDayCarry(t *testing.T) {
+	c, clock := newTestMBC3()
+
+	clock.advance(511 * 24 * time.Hour)
+	if got := readRTC(c, RTC_DL); got != 0xFF {
+		t.Errorf("DL on day 511: 0x%02X, want 0xFF", got)
+	}
+	if got := readRTC(c, RTC_DH); got != 0x01 {
+		t.Errorf("DH on day 511: 0x%02X, want 0x01", got)
+	}
+
+	// One more day wraps the counter to zero and sets the carry, which stays set
+	clock.advance(24 * time.Hour)
+	if got := readRTC(c, RTC_DL); got != 0x00 {
+		t.Errorf("DL after overflow: 0x%02X, want 0x00", got)
+	}
+	if got := readRTC(c, RTC_DH); got != 0x80 {
+		t.Errorf("DH after overflow: 0x%02X, want 0x80", got)
+	}
+
+	clock.advance(24 * time.Hour)
+	if got := readRTC(c, RTC_DH); got != 0x80 {
+		t.Errorf("DH a day after overflow: 0x%02X, want 0x80", got)
+	}
+
+	// Only writing DH clears it
+	c.write(0x4000, RTC_DH)
+	c.write(EXT_RAM, 0x00)
+	if got := readRTC(c, RTC_DH); got != 0x00 {
+		t.Errorf("DH after clearing: 0x%02X, want 0x00", got)
+	}
+}
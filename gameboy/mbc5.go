@@ -0,0 +1,90 @@
+package gameboy
+
+// MBC5 supports up to 8MB of ROM, 128KB of RAM and optionally a rumble motor
+// https://gbdev.io/pandocs/MBC5.html
+type mbc5 struct {
+	rom []byte
+	ram []byte
+
+	ramEnabled bool
+	romBank    uint16 // 9 bit ROM bank, low byte at 0x2000-0x2FFF, bit 8 at 0x3000-0x3FFF
+	ramBank    byte   // 4 bit RAM bank register (0x4000-0x5FFF)
+
+	// On rumble carts bit 3 of the RAM bank register drives the motor
+	hasRumble bool
+	rumbleOn  bool
+	rumble    func(on bool)
+}
+
+func newMBC5(rom []byte, ramSize int, hasRumble bool, rumble func(on bool)) *mbc5 {
+	return &mbc5{
+		rom:       rom,
+		ram:       make([]byte, ramSize),
+		romBank:   1,
+		hasRumble: hasRumble,
+		rumble:    rumble,
+	}
+}
+
+func (c *mbc5) read(addr uint16) byte {
+	switch {
+	case addr < ROM_BANK:
+		return c.rom[addr]
+
+	case addr < VRAM:
+		return c.rom[c.romOffset()+int(addr-ROM_BANK)]
+
+	case addr >= EXT_RAM && addr < WRAM:
+		if !c.ramEnabled || len(c.ram) == 0 {
+			return 0xFF
+		}
+		return c.ram[c.ramOffset(addr)]
+	}
+
+	return 0xFF
+}
+
+func (c *mbc5) write(addr uint16, data byte) (stored bool) {
+	switch {
+	case addr < 0x2000:
+		c.ramEnabled = data&0x0F == 0x0A
+
+	case addr < 0x3000:
+		// Unlike MBC1 & MBC3, bank 0 can be mapped here
+		c.romBank = c.romBank&0x100 | uint16(data)
+
+	case addr < 0x4000:
+		c.romBank = c.romBank&0xFF | uint16(data&0x01)<<8
+
+	case addr < 0x6000:
+		c.ramBank = data & 0x0F
+
+		if c.hasRumble {
+			c.ramBank = data & 0x07
+
+			on := checkBit(data, 3)
+			if on != c.rumbleOn {
+				c.rumbleOn = on
+				if c.rumble != nil {
+					c.rumble(on)
+				}
+			}
+		}
+
+	case addr >= EXT_RAM && addr < WRAM:
+		if c.ramEnabled && len(c.ram) > 0 {
+			c.ram[c.ramOffset(addr)] = data
+			stored = true
+		}
+	}
+
+	return stored
+}
+
+func (c *mbc5) romOffset() int {
+	return (int(c.romBank) % (len(c.rom) / ROM_BANK_SIZE)) * ROM_BANK_SIZE
+}
+
+func (c *mbc5) ramOffset(addr uint16) int {
+	return (int(c.ramBank)*RAM_BANK_SIZE + int(addr-EXT_RAM)) % len(c.ram)
+}
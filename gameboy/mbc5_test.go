@@ -0,0 +1,95 @@
+package gameboy
+
+import "testing"
+
+// Read the bank number at 0x4000, with the upper bit from the second byte of the bank
+func readMBC5Bank(c *mbc5) int {
+	return int(c.read(ROM_BANK+1))<<8 | int(c.read(ROM_BANK))
+}
+
+func TestMBC5NineBitROMBank(t *testing.T) {
+	rom := testROM(512)
+	for b := 0; b < 512; b++ {
+		rom[b*ROM_BANK_SIZE+1] = byte(b >> 8)
+	}
+	c := newMBC5(rom, 0, false, nil)
+
+	for _, tc := range []struct {
+		low, high byte
+		want      int
+	}{
+		{0x00, 0x00, 0x000}, // Bank 0 isn't remapped to 1
+		{0x05, 0x00, 0x005},
+		{0x05, 0x01, 0x105},
+		{0xFF, 0x01, 0x1FF},
+		{0x00, 0x01, 0x100},
+		{0x00, 0xFE, 0x000}, // Only bit 0 of the high register is used
+	} {
+		c.write(0x2000, tc.low)
+		c.write(0x3000, tc.high)
+		if got := readMBC5Bank(c); got != tc.want {
+			t.Errorf("low 0x%02X high 0x%02X: bank 0x%03X, want 0x%03X", tc.low, tc.high, got, tc.want)
+		}
+	}
+
+	// The two registers are independent, writing one keeps the other
+	c.write(0x3000, 0x01)
+	c.write(0x2000, 0x02)
+	c.write(0x2FFF, 0x03)
+	if got := readMBC5Bank(c); got != 0x103 {
+		t.Errorf("bank 0x%03X after writing the low byte, want 0x103", got)
+	}
+	c.write(0x3FFF, 0x00)
+	if got := readMBC5Bank(c); got != 0x003 {
+		t.Errorf("bank 0x%03X after writing the high bit, want 0x003", got)
+	}
+}
+
+func TestMBC5RumbleRAMBanks(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		rumble bool
+		bank   byte
+		want   byte
+	}{
+		// Bit 3 is the motor on rumble carts, so 0x0A is RAM bank 2
+		{"rumble", true, 0x0A, 2},
+		{"no rumble", false, 0x0A, 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newMBC5(testROM(4), 16*RAM_BANK_SIZE, tc.rumble, nil)
+			c.write(0x0000, 0x0A)
+
+			for bank := 0; bank < 16; bank++ {
+				c.ram[bank*RAM_BANK_SIZE] = byte(bank)
+			}
+
+			c.write(0x4000, tc.bank)
+			if got := c.read(EXT_RAM); got != tc.want {
+				t.Errorf("RAM bank register 0x%02X: read bank %d, want %d", tc.bank, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMBC5RumbleCallback(t *testing.T) {
+	var calls []bool
+	c := newMBC5(testROM(4), 4*RAM_BANK_SIZE, true, func(on bool) {
+		calls = append(calls, on)
+	})
+
+	// Only changes of bit 3 call back, the RAM bank bits don't matter
+	for _, data := range []byte{0x00, 0x08, 0x09, 0x0B, 0x03, 0x01, 0x08} {
+		c.write(0x4000, data)
+	}
+
+	want := []bool{true, false, true}
+	if len(calls) != len(want) {
+		t.Fatalf("rumble called %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("rumble called %v, want %v", calls, want)
+		}
+	}
+}
@@ -1,7 +1,5 @@
 package gameboy
 
-import "log"
-
 var opcodes = [0x100]func(cpu *CPU){
 	// NOP
 	0x00: func(cpu *CPU) {},
@@ -10,7 +8,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x01: func(cpu *CPU) { cpu.bc = cpu.fetchPC16() },
 
 	// LD (BC), A
-	0x02: func(cpu *CPU) { cpu.mapper.write(cpu.bc, cpu.A()) },
+	0x02: func(cpu *CPU) { cpu.write(cpu.bc, cpu.A()) },
 
 	// INC BC
 	0x03: func(cpu *CPU) { cpu.bc++ },
@@ -39,15 +37,15 @@ var opcodes = [0x100]func(cpu *CPU){
 	// LD (nn), SP
 	0x08: func(cpu *CPU) {
 		addr := cpu.fetchPC16()
-		cpu.mapper.write(addr, byte(cpu.sp))
-		cpu.mapper.write(addr+1, byte(cpu.sp>>8))
+		cpu.write(addr, byte(cpu.sp))
+		cpu.write(addr+1, byte(cpu.sp>>8))
 	},
 
 	// LD HL, BC
 	0x09: func(cpu *CPU) { cpu.hl = cpu.wordAdd(cpu.hl, cpu.bc) },
 
 	// LD A, (BC)
-	0x0A: func(cpu *CPU) { cpu.setA(cpu.mapper.read(cpu.bc)) },
+	0x0A: func(cpu *CPU) { cpu.setA(cpu.read(cpu.bc)) },
 
 	// DEC BC
 	0x0B: func(cpu *CPU) { cpu.bc-- },
@@ -74,13 +72,13 @@ var opcodes = [0x100]func(cpu *CPU){
 	},
 
 	// STOP
-	0x10: func(cpu *CPU) { log.Fatalf("STOP instruction not implemented") },
+	0x10: func(cpu *CPU) { cpu.stop() },
 
 	// LD DE, nn
 	0x11: func(cpu *CPU) { cpu.de = cpu.fetchPC16() },
 
 	// LD (DE), A
-	0x12: func(cpu *CPU) { cpu.mapper.write(cpu.de, cpu.A()) },
+	0x12: func(cpu *CPU) { cpu.write(cpu.de, cpu.A()) },
 
 	// INC DE
 	0x13: func(cpu *CPU) { cpu.de++ },
@@ -121,7 +119,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x19: func(cpu *CPU) { cpu.hl = cpu.wordAdd(cpu.hl, cpu.de) },
 
 	// LD A, (DE)
-	0x1A: func(cpu *CPU) { cpu.setA(cpu.mapper.read(cpu.de)) },
+	0x1A: func(cpu *CPU) { cpu.setA(cpu.read(cpu.de)) },
 
 	// DEC DE
 	0x1B: func(cpu *CPU) { cpu.de-- },
@@ -156,6 +154,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x20: func(cpu *CPU) {
 		e := cpu.fetchPC() // Important fetch & inc PC before the condition!
 		if !cpu.getFlagZ() {
+			cpu.branched = true
 			cpu.pc += uint16(int8(e))
 		}
 	},
@@ -165,7 +164,7 @@ var opcodes = [0x100]func(cpu *CPU){
 
 	// LD (HL+), A
 	0x22: func(cpu *CPU) {
-		cpu.mapper.write(cpu.hl, cpu.A())
+		cpu.write(cpu.hl, cpu.A())
 		cpu.hl++
 	},
 
@@ -216,6 +215,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x28: func(cpu *CPU) {
 		e := cpu.fetchPC() // Important fetch & inc PC before the condition!
 		if cpu.getFlagZ() {
+			cpu.branched = true
 			cpu.pc += uint16(int8(e))
 		}
 	},
@@ -225,7 +225,7 @@ var opcodes = [0x100]func(cpu *CPU){
 
 	// LD A, (HL+)
 	0x2A: func(cpu *CPU) {
-		cpu.setA(cpu.mapper.read(cpu.hl))
+		cpu.setA(cpu.read(cpu.hl))
 		cpu.hl++
 	},
 
@@ -252,6 +252,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x30: func(cpu *CPU) {
 		e := cpu.fetchPC() // Important fetch & inc PC before the condition!
 		if !cpu.getFlagC() {
+			cpu.branched = true
 			cpu.pc += uint16(int8(e))
 		}
 	},
@@ -261,7 +262,7 @@ var opcodes = [0x100]func(cpu *CPU){
 
 	// LD [HL-], A
 	0x32: func(cpu *CPU) {
-		cpu.mapper.write(cpu.hl, cpu.A())
+		cpu.write(cpu.hl, cpu.A())
 		cpu.hl--
 	},
 
@@ -270,18 +271,18 @@ var opcodes = [0x100]func(cpu *CPU){
 
 	// INC (HL)
 	0x34: func(cpu *CPU) {
-		value := cpu.mapper.read(cpu.hl)
-		cpu.mapper.write(cpu.hl, cpu.byteInc(value))
+		value := cpu.read(cpu.hl)
+		cpu.write(cpu.hl, cpu.byteInc(value))
 	},
 
 	// DEC (HL)
 	0x35: func(cpu *CPU) {
-		value := cpu.mapper.read(cpu.hl)
-		cpu.mapper.write(cpu.hl, cpu.byteDec(value))
+		value := cpu.read(cpu.hl)
+		cpu.write(cpu.hl, cpu.byteDec(value))
 	},
 
 	// LD (HL), n
-	0x36: func(cpu *CPU) { cpu.mapper.write(cpu.hl, cpu.fetchPC()) },
+	0x36: func(cpu *CPU) { cpu.write(cpu.hl, cpu.fetchPC()) },
 
 	// SCF
 	0x37: func(cpu *CPU) {
@@ -294,6 +295,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x38: func(cpu *CPU) {
 		e := cpu.fetchPC() // Important fetch & inc PC before the condition!
 		if cpu.getFlagC() {
+			cpu.branched = true
 			cpu.pc += uint16(int8(e))
 		}
 	},
@@ -303,7 +305,7 @@ var opcodes = [0x100]func(cpu *CPU){
 
 	// LD A, (HL-)
 	0x3A: func(cpu *CPU) {
-		cpu.setA(cpu.mapper.read(cpu.hl))
+		cpu.setA(cpu.read(cpu.hl))
 		cpu.hl--
 	},
 
@@ -345,7 +347,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x45: func(cpu *CPU) { cpu.setB(cpu.L()) },
 
 	// LD B, (HL)
-	0x46: func(cpu *CPU) { cpu.setB(cpu.mapper.read(cpu.hl)) },
+	0x46: func(cpu *CPU) { cpu.setB(cpu.read(cpu.hl)) },
 
 	// LD B, A
 	0x47: func(cpu *CPU) { cpu.setB(cpu.A()) },
@@ -369,7 +371,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x4D: func(cpu *CPU) { cpu.setC(cpu.L()) },
 
 	// LD C, (HL)
-	0x4E: func(cpu *CPU) { cpu.setC(cpu.mapper.read(cpu.hl)) },
+	0x4E: func(cpu *CPU) { cpu.setC(cpu.read(cpu.hl)) },
 
 	// LD C, A
 	0x4F: func(cpu *CPU) { cpu.setC(cpu.A()) },
@@ -393,7 +395,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x55: func(cpu *CPU) { cpu.setD(cpu.L()) },
 
 	// LD D, (HL)
-	0x56: func(cpu *CPU) { cpu.setD(cpu.mapper.read(cpu.hl)) },
+	0x56: func(cpu *CPU) { cpu.setD(cpu.read(cpu.hl)) },
 
 	// LD D, A
 	0x57: func(cpu *CPU) { cpu.setD(cpu.A()) },
@@ -417,7 +419,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x5D: func(cpu *CPU) { cpu.setE(cpu.L()) },
 
 	// LD E, (HL)
-	0x5E: func(cpu *CPU) { cpu.setE(cpu.mapper.read(cpu.hl)) },
+	0x5E: func(cpu *CPU) { cpu.setE(cpu.read(cpu.hl)) },
 
 	// LD E, A
 	0x5F: func(cpu *CPU) { cpu.setE(cpu.A()) },
@@ -441,7 +443,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x65: func(cpu *CPU) { cpu.setH(cpu.L()) },
 
 	// LD H, (HL)
-	0x66: func(cpu *CPU) { cpu.setH(cpu.mapper.read(cpu.hl)) },
+	0x66: func(cpu *CPU) { cpu.setH(cpu.read(cpu.hl)) },
 
 	// LD H, A
 	0x67: func(cpu *CPU) { cpu.setH(cpu.A()) },
@@ -465,34 +467,34 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x6D: func(cpu *CPU) { cpu.setL(cpu.L()) },
 
 	// LD L, (HL)
-	0x6E: func(cpu *CPU) { cpu.setL(cpu.mapper.read(cpu.hl)) },
+	0x6E: func(cpu *CPU) { cpu.setL(cpu.read(cpu.hl)) },
 
 	// LD L, A
 	0x6F: func(cpu *CPU) { cpu.setL(cpu.A()) },
 
 	// LD (HL), B
-	0x70: func(cpu *CPU) { cpu.mapper.write(cpu.hl, cpu.B()) },
+	0x70: func(cpu *CPU) { cpu.write(cpu.hl, cpu.B()) },
 
 	// LD (HL), C
-	0x71: func(cpu *CPU) { cpu.mapper.write(cpu.hl, cpu.C()) },
+	0x71: func(cpu *CPU) { cpu.write(cpu.hl, cpu.C()) },
 
 	// LD (HL), D
-	0x72: func(cpu *CPU) { cpu.mapper.write(cpu.hl, cpu.D()) },
+	0x72: func(cpu *CPU) { cpu.write(cpu.hl, cpu.D()) },
 
 	// LD (HL), E
-	0x73: func(cpu *CPU) { cpu.mapper.write(cpu.hl, cpu.E()) },
+	0x73: func(cpu *CPU) { cpu.write(cpu.hl, cpu.E()) },
 
 	// LD (HL), H
-	0x74: func(cpu *CPU) { cpu.mapper.write(cpu.hl, cpu.H()) },
+	0x74: func(cpu *CPU) { cpu.write(cpu.hl, cpu.H()) },
 
 	// LD (HL), L
-	0x75: func(cpu *CPU) { cpu.mapper.write(cpu.hl, cpu.L()) },
+	0x75: func(cpu *CPU) { cpu.write(cpu.hl, cpu.L()) },
 
 	// HALT
-	0x76: func(cpu *CPU) { cpu.halted = true },
+	0x76: func(cpu *CPU) { cpu.halt() },
 
 	// LD (HL), A
-	0x77: func(cpu *CPU) { cpu.mapper.write(cpu.hl, cpu.A()) },
+	0x77: func(cpu *CPU) { cpu.write(cpu.hl, cpu.A()) },
 
 	// LD A, B
 	0x78: func(cpu *CPU) { cpu.setA(cpu.B()) },
@@ -513,7 +515,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x7D: func(cpu *CPU) { cpu.setA(cpu.L()) },
 
 	// LD A, (HL)
-	0x7E: func(cpu *CPU) { cpu.setA(cpu.mapper.read(cpu.hl)) },
+	0x7E: func(cpu *CPU) { cpu.setA(cpu.read(cpu.hl)) },
 
 	// LD A, A
 	0x7F: func(cpu *CPU) { cpu.setA(cpu.A()) },
@@ -537,7 +539,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x85: func(cpu *CPU) { cpu.setA(cpu.byteAdd(cpu.A(), cpu.L(), false)) },
 
 	// ADD A, (HL)
-	0x86: func(cpu *CPU) { cpu.setA(cpu.byteAdd(cpu.A(), cpu.mapper.read(cpu.hl), false)) },
+	0x86: func(cpu *CPU) { cpu.setA(cpu.byteAdd(cpu.A(), cpu.read(cpu.hl), false)) },
 
 	// ADD A
 	0x87: func(cpu *CPU) { cpu.setA(cpu.byteAdd(cpu.A(), cpu.A(), false)) },
@@ -561,7 +563,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x8D: func(cpu *CPU) { cpu.setA(cpu.byteAdd(cpu.A(), cpu.L(), true)) },
 
 	// ADC A, (HL)
-	0x8E: func(cpu *CPU) { cpu.setA(cpu.byteAdd(cpu.A(), cpu.mapper.read(cpu.hl), true)) },
+	0x8E: func(cpu *CPU) { cpu.setA(cpu.byteAdd(cpu.A(), cpu.read(cpu.hl), true)) },
 
 	// ADC A, A
 	0x8F: func(cpu *CPU) { cpu.setA(cpu.byteAdd(cpu.A(), cpu.A(), true)) },
@@ -585,7 +587,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x95: func(cpu *CPU) { cpu.setA(cpu.byteSub(cpu.A(), cpu.L(), false)) },
 
 	// SUB A, (HL)
-	0x96: func(cpu *CPU) { cpu.setA(cpu.byteSub(cpu.A(), cpu.mapper.read(cpu.hl), false)) },
+	0x96: func(cpu *CPU) { cpu.setA(cpu.byteSub(cpu.A(), cpu.read(cpu.hl), false)) },
 
 	// SUB A, A
 	0x97: func(cpu *CPU) { cpu.setA(cpu.byteSub(cpu.A(), cpu.A(), false)) },
@@ -609,7 +611,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0x9D: func(cpu *CPU) { cpu.setA(cpu.byteSub(cpu.A(), cpu.L(), true)) },
 
 	// SBC A, (HL)
-	0x9E: func(cpu *CPU) { cpu.setA(cpu.byteSub(cpu.A(), cpu.mapper.read(cpu.hl), true)) },
+	0x9E: func(cpu *CPU) { cpu.setA(cpu.byteSub(cpu.A(), cpu.read(cpu.hl), true)) },
 
 	// SBC A, A
 	0x9F: func(cpu *CPU) { cpu.setA(cpu.byteSub(cpu.A(), cpu.A(), true)) },
@@ -633,7 +635,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xA5: func(cpu *CPU) { cpu.setA(cpu.byteAND(cpu.A(), cpu.L())) },
 
 	// AND (HL)
-	0xA6: func(cpu *CPU) { cpu.setA(cpu.byteAND(cpu.A(), cpu.mapper.read(cpu.hl))) },
+	0xA6: func(cpu *CPU) { cpu.setA(cpu.byteAND(cpu.A(), cpu.read(cpu.hl))) },
 
 	// AND A
 	0xA7: func(cpu *CPU) { cpu.setA(cpu.byteAND(cpu.A(), cpu.A())) },
@@ -657,7 +659,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xAD: func(cpu *CPU) { cpu.setA(cpu.byteXOR(cpu.A(), cpu.L())) },
 
 	// XOR A, (HL)
-	0xAE: func(cpu *CPU) { cpu.setA(cpu.byteXOR(cpu.A(), cpu.mapper.read(cpu.hl))) },
+	0xAE: func(cpu *CPU) { cpu.setA(cpu.byteXOR(cpu.A(), cpu.read(cpu.hl))) },
 
 	// XOR A, A
 	0xAF: func(cpu *CPU) { cpu.setA(cpu.byteXOR(cpu.A(), cpu.A())) },
@@ -681,7 +683,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xB5: func(cpu *CPU) { cpu.setA(cpu.byteOR(cpu.A(), cpu.L())) },
 
 	// OR A, (HL)
-	0xB6: func(cpu *CPU) { cpu.setA(cpu.byteOR(cpu.A(), cpu.mapper.read(cpu.hl))) },
+	0xB6: func(cpu *CPU) { cpu.setA(cpu.byteOR(cpu.A(), cpu.read(cpu.hl))) },
 
 	// OR A, A
 	0xB7: func(cpu *CPU) { cpu.setA(cpu.byteOR(cpu.A(), cpu.A())) },
@@ -705,14 +707,17 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xBD: func(cpu *CPU) { cpu.cmp(cpu.A(), cpu.L()) },
 
 	// CP A, [HL]
-	0xBE: func(cpu *CPU) { cpu.cmp(cpu.A(), cpu.mapper.read(cpu.hl)) },
+	0xBE: func(cpu *CPU) { cpu.cmp(cpu.A(), cpu.read(cpu.hl)) },
 
 	// CP A
 	0xBF: func(cpu *CPU) { cpu.cmp(cpu.A(), cpu.A()) },
 
 	// RET NZ
 	0xC0: func(cpu *CPU) {
+		// The condition is checked in an internal cycle before popping
+		cpu.tick()
 		if !cpu.getFlagZ() {
+			cpu.branched = true
 			cpu.returnSub()
 		}
 	},
@@ -724,6 +729,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xC2: func(cpu *CPU) {
 		nn := cpu.fetchPC16()
 		if !cpu.getFlagZ() {
+			cpu.branched = true
 			cpu.pc = nn
 		}
 	},
@@ -735,6 +741,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xC4: func(cpu *CPU) {
 		nn := cpu.fetchPC16()
 		if !cpu.getFlagZ() {
+			cpu.branched = true
 			cpu.callSub(nn)
 		}
 	},
@@ -750,7 +757,10 @@ var opcodes = [0x100]func(cpu *CPU){
 
 	// RET Z
 	0xC8: func(cpu *CPU) {
+		// The condition is checked in an internal cycle before popping
+		cpu.tick()
 		if cpu.getFlagZ() {
+			cpu.branched = true
 			cpu.returnSub()
 		}
 	},
@@ -762,6 +772,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xCA: func(cpu *CPU) {
 		nn := cpu.fetchPC16()
 		if cpu.getFlagZ() {
+			cpu.branched = true
 			cpu.pc = nn
 		}
 	},
@@ -777,6 +788,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xCC: func(cpu *CPU) {
 		nn := cpu.fetchPC16()
 		if cpu.getFlagZ() {
+			cpu.branched = true
 			cpu.callSub(nn)
 		}
 	},
@@ -792,7 +804,10 @@ var opcodes = [0x100]func(cpu *CPU){
 
 	// RET NC
 	0xD0: func(cpu *CPU) {
+		// The condition is checked in an internal cycle before popping
+		cpu.tick()
 		if !cpu.getFlagC() {
+			cpu.branched = true
 			cpu.returnSub()
 		}
 	},
@@ -804,6 +819,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xD2: func(cpu *CPU) {
 		nn := cpu.fetchPC16()
 		if !cpu.getFlagC() {
+			cpu.branched = true
 			cpu.pc = nn
 		}
 	},
@@ -812,6 +828,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xD4: func(cpu *CPU) {
 		nn := cpu.fetchPC16()
 		if !cpu.getFlagC() {
+			cpu.branched = true
 			cpu.callSub(nn)
 		}
 	},
@@ -831,7 +848,10 @@ var opcodes = [0x100]func(cpu *CPU){
 
 	// RET C
 	0xD8: func(cpu *CPU) {
+		// The condition is checked in an internal cycle before popping
+		cpu.tick()
 		if cpu.getFlagC() {
+			cpu.branched = true
 			cpu.returnSub()
 		}
 	},
@@ -846,6 +866,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xDA: func(cpu *CPU) {
 		nn := cpu.fetchPC16()
 		if cpu.getFlagC() {
+			cpu.branched = true
 			cpu.pc = nn
 		}
 	},
@@ -854,6 +875,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xDC: func(cpu *CPU) {
 		nn := cpu.fetchPC16()
 		if cpu.getFlagC() {
+			cpu.branched = true
 			cpu.callSub(nn)
 		}
 	},
@@ -865,13 +887,13 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xDF: func(cpu *CPU) { cpu.callSub(0x0018) },
 
 	// LDH (n), A
-	0xE0: func(cpu *CPU) { cpu.mapper.write(0xFF00+uint16(cpu.fetchPC()), cpu.A()) },
+	0xE0: func(cpu *CPU) { cpu.write(0xFF00+uint16(cpu.fetchPC()), cpu.A()) },
 
 	// POP HL
 	0xE1: func(cpu *CPU) { cpu.hl = cpu.popStack() },
 
 	// LD (C), A
-	0xE2: func(cpu *CPU) { cpu.mapper.write(0xFF00+uint16(cpu.C()), cpu.A()) },
+	0xE2: func(cpu *CPU) { cpu.write(0xFF00+uint16(cpu.C()), cpu.A()) },
 
 	// PUSH HL
 	0xE5: func(cpu *CPU) { cpu.pushStack(cpu.hl) },
@@ -897,7 +919,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xE9: func(cpu *CPU) { cpu.pc = cpu.hl },
 
 	// LD (nn), A
-	0xEA: func(cpu *CPU) { cpu.mapper.write(cpu.fetchPC16(), cpu.A()) },
+	0xEA: func(cpu *CPU) { cpu.write(cpu.fetchPC16(), cpu.A()) },
 
 	// XOR A, n
 	0xEE: func(cpu *CPU) { cpu.setA(cpu.byteXOR(cpu.A(), cpu.fetchPC())) },
@@ -906,7 +928,7 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xEF: func(cpu *CPU) { cpu.callSub(0x0028) },
 
 	// LDH A, (n)
-	0xF0: func(cpu *CPU) { cpu.setA(cpu.mapper.read(0xFF00 + uint16(cpu.fetchPC()))) },
+	0xF0: func(cpu *CPU) { cpu.setA(cpu.read(0xFF00 + uint16(cpu.fetchPC()))) },
 
 	// POP AF
 	0xF1: func(cpu *CPU) {
@@ -916,10 +938,13 @@ var opcodes = [0x100]func(cpu *CPU){
 	},
 
 	// LD A, (C)
-	0xF2: func(cpu *CPU) { cpu.setA(cpu.mapper.read(0xFF00 + uint16(cpu.C()))) },
+	0xF2: func(cpu *CPU) { cpu.setA(cpu.read(0xFF00 + uint16(cpu.C()))) },
 
 	// DI
-	0xF3: func(cpu *CPU) { cpu.ime = false },
+	0xF3: func(cpu *CPU) {
+		cpu.ime = false
+		cpu.enableIME = false
+	},
 
 	// PUSH AF
 	0xF5: func(cpu *CPU) { cpu.pushStack(cpu.af) },
@@ -945,10 +970,10 @@ var opcodes = [0x100]func(cpu *CPU){
 	0xF9: func(cpu *CPU) { cpu.sp = cpu.hl },
 
 	// LD A, (nn)
-	0xFA: func(cpu *CPU) { cpu.setA(cpu.mapper.read(cpu.fetchPC16())) },
+	0xFA: func(cpu *CPU) { cpu.setA(cpu.read(cpu.fetchPC16())) },
 
 	// EI
-	0xFB: func(cpu *CPU) { cpu.ime = true },
+	0xFB: func(cpu *CPU) { cpu.enableIME = true },
 
 	// CP
 	0xFE: func(cpu *CPU) { cpu.cmp(cpu.A(), cpu.fetchPC()) },
@@ -1,11 +1,15 @@
 package gameboy
 
 import (
+	"image"
 	"image/color"
-
-	"github.com/hajimehoshi/ebiten/v2"
+	"sort"
 )
 
+// Size of the LCD in pixels
+const SCREEN_WIDTH = 160
+const SCREEN_HEIGHT = 144
+
 // VRAM offests
 const TILE_DATA_0 = 0x8000
 const TILE_DATA_1 = 0x8800
@@ -13,16 +17,52 @@ const TILE_DATA_2 = 0x9000
 const TILE_MAP_0 = uint16(0x9800)
 const TILE_MAP_1 = uint16(0x9C00)
 
+// PPU modes, as reported in the lower 2 bits of STAT
+const MODE_HBLANK = 0
+const MODE_VBLANK = 1
+const MODE_OAM_SCAN = 2
+const MODE_DRAWING = 3
+
+// Timing of each scanline in dots, mode 3 length varies on hardware but is fixed here
+const DOTS_PER_LINE = 456
+const DOTS_OAM_SCAN = 80
+const DOTS_DRAWING = 172
+const LINES_PER_FRAME = 154
+
+// Hardware limit on the number of sprites drawn on each scanline
+const MAX_SPRITES_PER_LINE = 10
+
 type PPU struct {
 	mapper     *Mapper
 	emuPalette [4]color.RGBA
-	screen     *ebiten.Image
-	tileCache  map[uint16]*ebiten.Image
 
-	// Scanline register
+	// Screen buffer holding the shade (0-3) of each pixel, after the palettes are applied
+	// Scanlines are drawn into the back buffer, which is copied to the screen at VBlank
+	screen     [SCREEN_WIDTH * SCREEN_HEIGHT]byte
+	backBuffer [SCREEN_WIDTH * SCREEN_HEIGHT]byte
+
+	// Color IDs (before the palette) of the BG & window on the current line, for sprite priority
+	lineColorIds [SCREEN_WIDTH]byte
+
+	// Scanline register, the current mode & dots spent on the current scanline
 	scanline   byte
+	mode       byte
 	dotCounter int
 
+	// State of the internal STAT interrupt line, interrupts only fire when it goes high
+	statLine bool
+
+	// The window has its own line counter, which only moves on lines where the window
+	// was drawn. It's only shown once LY has matched WY at some point in the frame
+	windowLine      int
+	windowTriggered bool
+
+	// Tracks LCDC bit 7, when the LCD is turned back on the first line skips the OAM
+	// scan and the first frame isn't shown, the screen stays blank until the next one
+	lcdOn     bool
+	firstLine bool
+	skipFrame bool
+
 	gb *Gameboy
 }
 
@@ -49,9 +89,8 @@ func NewPPU(mapper *Mapper) *PPU {
 	ppu := &PPU{
 		emuPalette: pallet,
 		mapper:     mapper,
-
-		// Internal screen buffer
-		screen: ebiten.NewImage(160, 144),
+		mode:       MODE_OAM_SCAN,
+		lcdOn:      true,
 	}
 
 	return ppu
@@ -78,49 +117,13 @@ func (ppu *PPU) newSprite(addr uint16) Sprite {
 	return sprite
 }
 
-// This function creates a new 8x8 image for a tile reading 16 bytes from the VRAM
-// and using the BGP register to lookup the palette
-func (ppu *PPU) getTileImage(addr uint16, palette byte, isObj bool) *ebiten.Image {
-	// Check if the tile is already in the cache
-	if _, ok := ppu.tileCache[addr]; ok {
-		return ppu.tileCache[addr]
-	}
-
-	pixels := make([]byte, 8*8*4)
-
-	for tileByteIndex := uint16(0); tileByteIndex < 16; tileByteIndex += 2 {
-		byte1 := ppu.mapper.read(addr + tileByteIndex)
-		byte2 := ppu.mapper.read(addr + tileByteIndex + 1)
-		y := int(tileByteIndex / 2)
-		for bit := 0; bit < 8; bit++ {
-			// Combine the bits to get the color index
-			colorId := (byte1 >> (7 - bit) & 1) | ((byte2 >> (7 - bit) & 1) << 1)
+// Gets the color ID (0-3) of one pixel in a tile, reading the 2 bytes for the row from VRAM
+func (ppu *PPU) getTilePixel(addr uint16, row, col int) byte {
+	byte1 := ppu.mapper.read(addr + uint16(row*2))
+	byte2 := ppu.mapper.read(addr + uint16(row*2) + 1)
 
-			// Set the final color in the pixel array
-			if colorId == 0 && isObj {
-				// ID 0 is alway transparent for OBJ
-				pixels[(y*8+bit)*4] = 0
-				pixels[(y*8+bit)*4+1] = 0
-				pixels[(y*8+bit)*4+2] = 0
-				pixels[(y*8+bit)*4+3] = 0
-			} else {
-				// Use the palette, which is byte with 2bit colorId -> Value mapping
-				// https://gbdev.io/pandocs/Palettes.html
-				colorVal := palette >> (colorId * 2) & 0x3
-				pixels[(y*8+bit)*4] = ppu.emuPalette[colorVal].R
-				pixels[(y*8+bit)*4+1] = ppu.emuPalette[colorVal].G
-				pixels[(y*8+bit)*4+2] = ppu.emuPalette[colorVal].B
-				pixels[(y*8+bit)*4+3] = 255
-			}
-		}
-	}
-
-	img := ebiten.NewImage(8, 8)
-	img.WritePixels(pixels)
-
-	// Cache the tile
-	ppu.tileCache[addr] = img
-	return img
+	// Combine the bits to get the color index
+	return (byte1 >> (7 - col) & 1) | ((byte2 >> (7 - col) & 1) << 1)
 }
 
 func (ppu *PPU) getTileAddr(tileNum byte) uint16 {
@@ -133,91 +136,302 @@ func (ppu *PPU) getTileAddr(tileNum byte) uint16 {
 	return uint16(int(TILE_DATA_2) + int(int8(tileNum))*16)
 }
 
-func (ppu *PPU) render() {
-	mapBase := TILE_MAP_0
-	if ppu.GetLCDCBit(3) == 1 {
-		mapBase = TILE_MAP_1
-	}
+// Draws a single scanline into the back buffer, the scroll, palette & LCDC registers
+// are read as the line is drawn, so changing them between lines works like hardware
+func (ppu *PPU) renderScanline() {
+	line := int(ppu.scanline)
+	out := ppu.backBuffer[line*SCREEN_WIDTH : (line+1)*SCREEN_WIDTH]
 
-	// Reset cache on each render, coz palette can change
-	ppu.tileCache = make(map[uint16]*ebiten.Image)
+	if ppu.scanline == ppu.mapper.read(WY) {
+		ppu.windowTriggered = true
+	}
 
-	// get SCROLL_Y and SCROLL_X
-	scrollY := float64(0) //ppu.mapper.read(SCY))
-	scrollX := float64(0)
+	// Background, the 256x256 tile map wraps around when scrolled past the edge
+	bgp := ppu.mapper.read(BGP)
+	if ppu.GetLCDCBit(0) == 1 {
+		mapBase := TILE_MAP_0
+		if ppu.GetLCDCBit(3) == 1 {
+			mapBase = TILE_MAP_1
+		}
 
-	// Read the 1024 bytes of tile map data
-	// And render into the screen at the correct position
-	for i := uint16(0); i < 1024; i++ {
-		op := &ebiten.DrawImageOptions{}
-		op.GeoM.Translate(float64((i%32)*8)+scrollX, float64((i/32)*8)-scrollY)
+		y := (line + int(ppu.mapper.read(SCY))) & 0xFF
+		scrollX := int(ppu.mapper.read(SCX))
 
-		tilenum := int(ppu.mapper.read(mapBase + i))
-		tileAddr := ppu.getTileAddr(byte(tilenum))
-		pal := ppu.mapper.read(BGP)
-		ppu.screen.DrawImage(ppu.getTileImage(tileAddr, pal, false), op)
-	}
+		for screenX := 0; screenX < SCREEN_WIDTH; screenX++ {
+			x := (screenX + scrollX) & 0xFF
+			tileNum := ppu.mapper.read(mapBase + uint16(y/8*32+x/8))
+			colorId := ppu.getTilePixel(ppu.getTileAddr(tileNum), y%8, x%8)
+			ppu.lineColorIds[screenX] = colorId
 
-	// Handle OAM and render 40 sprites
-	for i := 0; i < 40; i++ {
-		addr := OAM + uint16(i*4)
-		sprite := ppu.newSprite(addr)
-		if sprite.y == 0 && sprite.x == 0 || sprite.y >= 144 || sprite.x >= 160 {
-			continue
+			// Use the palette, which is byte with 2bit colorId -> Value mapping
+			// https://gbdev.io/pandocs/Palettes.html
+			out[screenX] = bgp >> (colorId * 2) & 0x3
 		}
 
-		// HACK: REMOVE LATER
-		if sprite.tile == 0xff {
-			continue
+		ppu.renderWindow(out, bgp)
+	} else {
+		// With the background disabled it's drawn as white, and so is the window
+		for screenX := range out {
+			out[screenX] = 0
+			ppu.lineColorIds[screenX] = 0
 		}
+	}
 
-		op := &ebiten.DrawImageOptions{}
-		screenY := int(sprite.y) - 16
-		screenX := int(sprite.x) - 8
+	if ppu.GetLCDCBit(1) == 1 {
+		ppu.renderSprites(out)
+	}
+}
 
-		if sprite.flipX {
-			op.GeoM.Scale(-1, 1)
-			screenX += 8
+// Finds the sprites on the current line, like the OAM scan in mode 2. Only the first
+// 10 in OAM order are picked, no matter where they are horizontally
+func (ppu *PPU) scanOAM() []Sprite {
+	height := 8
+	if ppu.GetLCDCBit(2) == 1 {
+		height = 16
+	}
+
+	sprites := make([]Sprite, 0, MAX_SPRITES_PER_LINE)
+	for i := 0; i < 40 && len(sprites) < MAX_SPRITES_PER_LINE; i++ {
+		sprite := ppu.newSprite(OAM + uint16(i*4))
+
+		row := int(ppu.scanline) - (int(sprite.y) - 16)
+		if row >= 0 && row < height {
+			sprites = append(sprites, sprite)
 		}
+	}
+
+	// On DMG the sprite with the smallest X is drawn on top, if X is the same then the
+	// first in OAM wins. Sorting is stable so the OAM order is kept for equal X
+	sort.SliceStable(sprites, func(a, b int) bool {
+		return sprites[a].x < sprites[b].x
+	})
+
+	return sprites
+}
+
+// Draws the sprites (OBJ) over the current line
+func (ppu *PPU) renderSprites(out []byte) {
+	height := 8
+	if ppu.GetLCDCBit(2) == 1 {
+		height = 16
+	}
+
+	// Pixels which already have a sprite drawn, by a sprite with higher priority
+	var drawn [SCREEN_WIDTH]bool
+
+	for _, sprite := range ppu.scanOAM() {
+		row := int(ppu.scanline) - (int(sprite.y) - 16)
 		if sprite.flipY {
-			op.GeoM.Scale(1, -1)
-			screenY += 8
+			row = height - 1 - row
+		}
+
+		// Tile addressing is more simple for sprites, in 8x16 mode the tiles
+		// come in pairs and bit 0 of the tile number is ignored
+		tile := sprite.tile
+		if height == 16 {
+			tile &= 0xFE
 		}
+		tileAddr := TILE_DATA_0 + uint16(tile)*16
 
-		op.GeoM.Translate(float64(screenX), float64(screenY))
+		for col := 0; col < 8; col++ {
+			screenX := int(sprite.x) - 8 + col
+			if screenX < 0 || screenX >= SCREEN_WIDTH || drawn[screenX] {
+				continue
+			}
 
-		// Tile addressing is more simple for sprites
-		tileAddr := TILE_DATA_0 + uint16(sprite.tile)*16
+			tileCol := col
+			if sprite.flipX {
+				tileCol = 7 - col
+			}
+
+			// ID 0 is alway transparent for OBJ, and lets lower priority sprites show through
+			colorId := ppu.getTilePixel(tileAddr, row, tileCol)
+			if colorId == 0 {
+				continue
+			}
+			drawn[screenX] = true
 
-		ppu.screen.DrawImage(ppu.getTileImage(tileAddr, sprite.palette, true), op)
+			// Sprites flagged as behind the BG are only visible over BG color 0
+			if sprite.bgPriority && ppu.lineColorIds[screenX] != 0 {
+				continue
+			}
+
+			out[screenX] = sprite.palette >> (colorId * 2) & 0x3
+		}
 	}
 }
 
+// Draws the window over the background for the current line
+func (ppu *PPU) renderWindow(out []byte, bgp byte) {
+	// WX is offset by 7, values over 166 put the window off the right of the screen
+	wx := int(ppu.mapper.read(WX))
+	if ppu.GetLCDCBit(5) == 0 || !ppu.windowTriggered || wx > 166 {
+		return
+	}
+
+	mapBase := TILE_MAP_0
+	if ppu.GetLCDCBit(6) == 1 {
+		mapBase = TILE_MAP_1
+	}
+
+	// When WX is below 7 the window starts at the left edge, with its first few pixels cut off
+	// WX=166 leaves just the last pixel of the line, but the line counter still moves on
+	y := ppu.windowLine
+	for screenX := max(0, wx-7); screenX < SCREEN_WIDTH; screenX++ {
+		x := screenX - (wx - 7)
+		tileNum := ppu.mapper.read(mapBase + uint16(y/8*32+x/8))
+		colorId := ppu.getTilePixel(ppu.getTileAddr(tileNum), y%8, x%8)
+		ppu.lineColorIds[screenX] = colorId
+
+		out[screenX] = bgp >> (colorId * 2) & 0x3
+	}
+
+	ppu.windowLine++
+}
+
+// Advance the PPU by the given number of dots, stepping through the modes of each
+// scanline; OAM scan (2), drawing (3), HBlank (0) and then VBlank (1) after line 143
 func (ppu *PPU) cycle(clockCycles int) {
+	if ppu.GetLCDCBit(7) == 0 {
+		if ppu.lcdOn {
+			ppu.lcdOff()
+		}
+		return
+	}
+
+	if !ppu.lcdOn {
+		ppu.lcdOn = true
+		ppu.firstLine = true
+		ppu.skipFrame = true
+	}
+
 	ppu.dotCounter += clockCycles
 
-	// TODO: Still needs work
-	if ppu.dotCounter >= 456 {
-		ppu.dotCounter = 0
+	// The CPU might have changed STAT or LYC since last time
+	ppu.updateSTAT()
 
-		ppu.scanline++
+	for {
+		switch ppu.mode {
+		case MODE_OAM_SCAN:
+			if ppu.dotCounter < DOTS_OAM_SCAN {
+				return
+			}
+			ppu.mode = MODE_DRAWING
+			ppu.renderScanline()
 
-		if ppu.scanline == 144 {
-			// Request vblank interrupt
-			ppu.gb.requestInterrupt(INT_VBLANK)
-		}
+		case MODE_DRAWING:
+			if ppu.dotCounter < DOTS_OAM_SCAN+DOTS_DRAWING {
+				return
+			}
+			ppu.mode = MODE_HBLANK
+
+		case MODE_HBLANK:
+			// First line after the LCD is turned on starts in HBlank rather than OAM scan
+			if ppu.firstLine {
+				if ppu.dotCounter < DOTS_OAM_SCAN {
+					return
+				}
+				ppu.firstLine = false
+				ppu.mode = MODE_DRAWING
+				ppu.renderScanline()
+				break
+			}
 
-		if ppu.scanline > 153 {
-			ppu.scanline = 0
-			// Render has been moved to the main GB loop
-		}
+			if ppu.dotCounter < DOTS_PER_LINE {
+				return
+			}
+			ppu.nextLine()
+
+			if ppu.scanline == SCREEN_HEIGHT {
+				ppu.mode = MODE_VBLANK
+
+				// Frame is complete, show it and request vblank interrupt
+				if !ppu.skipFrame {
+					ppu.screen = ppu.backBuffer
+				}
+				ppu.skipFrame = false
+				ppu.gb.requestInterrupt(INT_VBLANK)
+
+				ppu.windowLine = 0
+				ppu.windowTriggered = false
+			} else {
+				ppu.mode = MODE_OAM_SCAN
+			}
+
+		case MODE_VBLANK:
+			if ppu.dotCounter < DOTS_PER_LINE {
+				return
+			}
+			ppu.nextLine()
 
-		ppu.mapper.write(LY, ppu.scanline)
-		if ppu.scanline == ppu.mapper.read(LYC) {
-			// set bit 2 of STAT
-			ppu.mapper.write(STAT, bitSet(ppu.mapper.read(STAT), 2))
+			if ppu.scanline == 0 {
+				ppu.mode = MODE_OAM_SCAN
+			}
 		}
+
+		ppu.updateSTAT()
+	}
+}
+
+// Turning the LCD off resets LY, holds the PPU in mode 0 and blanks the screen to white
+// Nothing happens, including interrupts, until it's turned back on
+func (ppu *PPU) lcdOff() {
+	ppu.lcdOn = false
+	ppu.scanline = 0
+	ppu.dotCounter = 0
+	ppu.mode = MODE_HBLANK
+	ppu.statLine = false
+	ppu.windowLine = 0
+	ppu.windowTriggered = false
+
+	ppu.mapper.io[LY-IO] = 0
+	ppu.mapper.io[STAT-IO] &^= 0x03
+
+	ppu.screen = [SCREEN_WIDTH * SCREEN_HEIGHT]byte{}
+}
+
+// Move on to the next scanline, wrapping back to the top after the last VBlank line
+func (ppu *PPU) nextLine() {
+	ppu.dotCounter -= DOTS_PER_LINE
+	ppu.scanline++
+
+	if ppu.scanline >= LINES_PER_FRAME {
+		ppu.scanline = 0
+	}
+
+	// LY is read only to the CPU, so it's set directly
+	ppu.mapper.io[LY-IO] = ppu.scanline
+}
+
+// LCD Status Register
+// Bit 6 - LYC=LY interrupt source
+// Bit 5 - Mode 2 (OAM scan) interrupt source
+// Bit 4 - Mode 1 (VBlank) interrupt source
+// Bit 3 - Mode 0 (HBlank) interrupt source
+// Bit 2 - LYC=LY coincidence flag (read only)
+// Bit 1-0 - PPU mode (read only)
+//
+// Updates the read only bits, then requests the LCD STAT interrupt if any enabled
+// source is active. All sources are ORed into one line and the interrupt only fires
+// when it goes from low to high, so one source can block another ("STAT blocking")
+func (ppu *PPU) updateSTAT() {
+	stat := ppu.mapper.io[STAT-IO]&0x78 | ppu.mode
+
+	coincidence := ppu.scanline == ppu.mapper.io[LYC-IO]
+	if coincidence {
+		stat = bitSet(stat, 2)
+	}
+	ppu.mapper.io[STAT-IO] = stat
+
+	line := coincidence && checkBit(stat, 6) ||
+		ppu.mode == MODE_HBLANK && checkBit(stat, 3) ||
+		ppu.mode == MODE_VBLANK && checkBit(stat, 4) ||
+		ppu.mode == MODE_OAM_SCAN && checkBit(stat, 5)
+
+	if line && !ppu.statLine {
+		ppu.gb.requestInterrupt(INT_LCD)
 	}
+	ppu.statLine = line
 }
 
 // LCD Control Register
@@ -232,3 +446,30 @@ func (ppu *PPU) cycle(clockCycles int) {
 func (ppu *PPU) GetLCDCBit(bit byte) byte {
 	return ppu.mapper.read(LCDC) >> bit & 1
 }
+
+// Converts the screen buffer into an RGBA image using the emulator palette
+func (ppu *PPU) screenRGBA() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, SCREEN_WIDTH, SCREEN_HEIGHT))
+	for i, shade := range ppu.screen {
+		c := ppu.emuPalette[shade]
+		img.Pix[i*4] = c.R
+		img.Pix[i*4+1] = c.G
+		img.Pix[i*4+2] = c.B
+		img.Pix[i*4+3] = c.A
+	}
+
+	return img
+}
+
+// Wraps the screen buffer as a paletted image, the pixels are the shades 0-3
+func (ppu *PPU) screenPaletted() *image.Paletted {
+	pal := color.Palette{}
+	for _, c := range ppu.emuPalette {
+		pal = append(pal, c)
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, SCREEN_WIDTH, SCREEN_HEIGHT), pal)
+	copy(img.Pix, ppu.screen[:])
+
+	return img
+}
@@ -0,0 +1,359 @@
+package gameboy
+
+import "testing"
+
+// Run the PPU for the given number of dots, 4 at a time like the CPU does
+func runDots(ppu *PPU, dots int) {
+	for ; dots > 0; dots -= 4 {
+		ppu.cycle(4)
+	}
+}
+
+func checkLine(t *testing.T, gb *Gameboy, when string, ly, mode byte) {
+	t.Helper()
+
+	if got := gb.mapper.read(LY); got != ly {
+		t.Errorf("%s: LY %d, want %d", when, got, ly)
+	}
+	if got := gb.mapper.read(STAT) & 0x03; got != mode {
+		t.Errorf("%s: STAT mode %d, want %d", when, got, mode)
+	}
+}
+
+func TestLCDOffAndOn(t *testing.T) {
+	gb := newTestGameboy(t)
+	ppu := gb.ppu
+
+	// Every pixel is colour 0, so with this palette a shown frame is all shade 3
+	gb.mapper.write(BGP, 0xFF)
+
+	runDots(ppu, DOTS_PER_LINE*50+100)
+	checkLine(t, gb, "LCD on", 50, MODE_DRAWING)
+
+	gb.mapper.write(LCDC, 0x11)
+	runDots(ppu, 4)
+	checkLine(t, gb, "LCD off", 0, MODE_HBLANK)
+
+	runDots(ppu, CYCLES_PER_FRAME)
+	checkLine(t, gb, "LCD off for a frame", 0, MODE_HBLANK)
+
+	// The first line after turning on starts in mode 0 instead of the OAM scan, but
+	// mode 3 still starts at the same dot
+	gb.mapper.write(LCDC, 0x91)
+	runDots(ppu, DOTS_OAM_SCAN-4)
+	checkLine(t, gb, "first line, before drawing", 0, MODE_HBLANK)
+
+	runDots(ppu, 4)
+	checkLine(t, gb, "first line, drawing", 0, MODE_DRAWING)
+
+	runDots(ppu, DOTS_DRAWING)
+	checkLine(t, gb, "first line, HBlank", 0, MODE_HBLANK)
+
+	runDots(ppu, DOTS_PER_LINE-DOTS_OAM_SCAN-DOTS_DRAWING)
+	checkLine(t, gb, "second line", 1, MODE_OAM_SCAN)
+
+	// The first frame isn't shown, the screen stays blank
+	runDots(ppu, DOTS_PER_LINE*(SCREEN_HEIGHT-1))
+	checkLine(t, gb, "first VBlank", SCREEN_HEIGHT, MODE_VBLANK)
+	if shade := ppu.screen[0]; shade != 0 {
+		t.Errorf("first frame shown, pixel shade %d, want 0", shade)
+	}
+
+	runDots(ppu, CYCLES_PER_FRAME)
+	checkLine(t, gb, "second VBlank", SCREEN_HEIGHT, MODE_VBLANK)
+	if shade := ppu.screen[0]; shade != 3 {
+		t.Errorf("second frame not shown, pixel shade %d, want 3", shade)
+	}
+}
+
+// Run the PPU until it reaches the given line, then the given number of dots into it
+func runToLine(ppu *PPU, ly byte, dots int) {
+	for ppu.scanline != ly {
+		ppu.cycle(4)
+	}
+	for ppu.dotCounter < dots {
+		ppu.cycle(4)
+	}
+}
+
+// Check and clear the LCD STAT interrupt flag
+func takeSTATInterrupt(gb *Gameboy) bool {
+	requested := gb.mapper.read(IF)&INT_LCD != 0
+	gb.mapper.write(IF, 0)
+
+	return requested
+}
+
+func TestSTATModeInterrupts(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		stat                  byte
+		oamScan, hblank, next bool
+	}{
+		{"OAM scan", 0x20, true, false, true},
+		{"HBlank", 0x08, false, true, false},
+		// The line stays high from HBlank into the next OAM scan, so that one is blocked
+		{"HBlank and OAM scan", 0x28, true, true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gb := newTestGameboy(t)
+			ppu := gb.ppu
+			gb.mapper.write(STAT, tc.stat)
+			gb.mapper.write(LYC, 0xFF)
+
+			runToLine(ppu, 0, 4)
+			if got := takeSTATInterrupt(gb); got != tc.oamScan {
+				t.Errorf("line 0 OAM scan: interrupt %v, want %v", got, tc.oamScan)
+			}
+
+			runDots(ppu, DOTS_OAM_SCAN+DOTS_DRAWING)
+			checkLine(t, gb, "line 0", 0, MODE_HBLANK)
+			if got := takeSTATInterrupt(gb); got != tc.hblank {
+				t.Errorf("line 0 HBlank: interrupt %v, want %v", got, tc.hblank)
+			}
+
+			runToLine(ppu, 1, 4)
+			checkLine(t, gb, "line 1", 1, MODE_OAM_SCAN)
+			if got := takeSTATInterrupt(gb); got != tc.next {
+				t.Errorf("line 1 OAM scan: interrupt %v, want %v", got, tc.next)
+			}
+		})
+	}
+}
+
+func TestSTATCoincidence(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		stat byte
+		want bool
+	}{
+		{"LYC only", 0x40, true},
+		// HBlank on line 4 holds the line high, so LY=LYC on line 5 doesn't fire
+		{"LYC blocked by HBlank", 0x48, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gb := newTestGameboy(t)
+			ppu := gb.ppu
+			gb.mapper.write(STAT, tc.stat)
+			gb.mapper.write(LYC, 5)
+
+			runToLine(ppu, 4, DOTS_PER_LINE-8)
+			if gb.mapper.read(STAT)&0x04 != 0 {
+				t.Error("coincidence flag set on line 4")
+			}
+			takeSTATInterrupt(gb)
+
+			runToLine(ppu, 5, 4)
+			if gb.mapper.read(STAT)&0x04 == 0 {
+				t.Error("coincidence flag clear on line 5")
+			}
+			if got := takeSTATInterrupt(gb); got != tc.want {
+				t.Errorf("line 5: interrupt %v, want %v", got, tc.want)
+			}
+
+			// The line stays high for the whole of line 5, so it only fires once
+			runToLine(ppu, 5, DOTS_PER_LINE-8)
+			if takeSTATInterrupt(gb) {
+				t.Error("second interrupt on line 5")
+			}
+		})
+	}
+}
+
+// Tile 1 is solid color 3, and tile 0 is left blank
+func solidTile(gb *Gameboy) {
+	for i := uint16(0); i < 16; i++ {
+		gb.mapper.write(TILE_DATA_0+16+i, 0xFF)
+	}
+}
+
+// Count the pixels of a line in the back buffer with the given shade
+func countShade(ppu *PPU, line int, shade byte) int {
+	n := 0
+	for _, s := range ppu.backBuffer[line*SCREEN_WIDTH : (line+1)*SCREEN_WIDTH] {
+		if s == shade {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Blank background, and a window using the map at 0x9C00 with the first 16 rows solid
+func newWindowTest(t *testing.T) (*Gameboy, *PPU) {
+	t.Helper()
+
+	gb := newTestGameboy(t)
+	solidTile(gb)
+	for i := uint16(0); i < 64; i++ {
+		gb.mapper.write(TILE_MAP_1+i, 0x01)
+	}
+	gb.mapper.write(BGP, 0xE4)
+	gb.mapper.write(WY, 0)
+	gb.mapper.write(WX, 7)
+	gb.mapper.write(LCDC, 0xF1)
+
+	return gb, gb.ppu
+}
+
+func TestWindowLineCounter(t *testing.T) {
+	gb, ppu := newWindowTest(t)
+
+	runToLine(ppu, 10, 0)
+	if ppu.windowLine != 10 {
+		t.Fatalf("window line %d after 10 lines, want 10", ppu.windowLine)
+	}
+
+	// Hidden for 10 lines, the counter doesn't move
+	gb.mapper.write(LCDC, 0xD1)
+	runToLine(ppu, 20, 0)
+	if n := countShade(ppu, 15, 3); n != 0 {
+		t.Errorf("line 15 with the window off: %d window pixels, want 0", n)
+	}
+	if ppu.windowLine != 10 {
+		t.Fatalf("window line %d after hiding it, want 10", ppu.windowLine)
+	}
+
+	// Shown again it carries on from row 10, which is solid, where row 20 would be blank
+	gb.mapper.write(LCDC, 0xF1)
+	runToLine(ppu, 21, 0)
+	if n := countShade(ppu, 20, 3); n != SCREEN_WIDTH {
+		t.Errorf("line 20: %d window pixels, want %d from window row 10", n, SCREEN_WIDTH)
+	}
+
+	// Reset for the next frame
+	runToLine(ppu, 0, 0)
+	if ppu.windowLine != 0 {
+		t.Errorf("window line %d at the start of a frame, want 0", ppu.windowLine)
+	}
+}
+
+func TestWindowWX(t *testing.T) {
+	for _, tc := range []struct {
+		wx      byte
+		pixels  int
+		counter int
+	}{
+		{0, SCREEN_WIDTH, 1},
+		{7, SCREEN_WIDTH, 1},
+		{87, 80, 1},
+		// Just the last pixel, the counter still moves
+		{166, 1, 1},
+		// Off the screen, the window isn't drawn at all
+		{167, 0, 0},
+		{255, 0, 0},
+	} {
+		gb, ppu := newWindowTest(t)
+		gb.mapper.write(WX, tc.wx)
+
+		runToLine(ppu, 1, 0)
+		if n := countShade(ppu, 0, 3); n != tc.pixels {
+			t.Errorf("WX %d: %d window pixels, want %d", tc.wx, n, tc.pixels)
+		}
+		if ppu.windowLine != tc.counter {
+			t.Errorf("WX %d: window line %d, want %d", tc.wx, ppu.windowLine, tc.counter)
+		}
+	}
+}
+
+func TestWindowWY(t *testing.T) {
+	gb, ppu := newWindowTest(t)
+	gb.mapper.write(WY, 50)
+
+	runToLine(ppu, 51, 0)
+	if n := countShade(ppu, 49, 3); n != 0 {
+		t.Errorf("line 49 above WY: %d window pixels, want 0", n)
+	}
+	if n := countShade(ppu, 50, 3); n != SCREEN_WIDTH {
+		t.Errorf("line 50: %d window pixels, want %d", n, SCREEN_WIDTH)
+	}
+
+	// Once triggered, moving WY below the current line doesn't hide it for the frame
+	gb.mapper.write(WY, 100)
+	runToLine(ppu, 53, 0)
+	if ppu.windowLine != 3 {
+		t.Errorf("window line %d after moving WY, want 3", ppu.windowLine)
+	}
+}
+
+// Put a sprite in OAM using the solid tile 1, on the lines from 0
+func setSprite(gb *Gameboy, i int, x, attrs byte) {
+	copy(gb.mapper.oam[i*4:], []byte{16, x, 0x01, attrs})
+}
+
+// Blank background, sprites on, OBP0 draws color 3 as shade 3 and OBP1 as shade 1
+func newSpriteTest(t *testing.T) (*Gameboy, *PPU) {
+	t.Helper()
+
+	gb := newTestGameboy(t)
+	solidTile(gb)
+	for i := range gb.mapper.oam {
+		gb.mapper.oam[i] = 0
+	}
+	gb.mapper.write(BGP, 0xE4)
+	gb.mapper.write(OBP0, 0xE4)
+	gb.mapper.write(OBP1, 0x54)
+	gb.mapper.write(LCDC, 0x93)
+
+	return gb, gb.ppu
+}
+
+func TestSpritesPerLine(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		first byte // X of the first sprite in OAM
+		want  int
+	}{
+		{"11 on screen", 8, 10 * 8},
+		// Sprites off the side of the screen still count towards the limit
+		{"first off screen", 0, 9 * 8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gb, ppu := newSpriteTest(t)
+			setSprite(gb, 0, tc.first, 0)
+			for i := 1; i <= 10; i++ {
+				setSprite(gb, i, byte(8+i*10), 0)
+			}
+
+			runToLine(ppu, 1, 0)
+			if n := countShade(ppu, 0, 3); n != tc.want {
+				t.Errorf("%d sprite pixels, want %d", n, tc.want)
+			}
+			if got := ppu.backBuffer[8+10*10-8]; got != 0 {
+				t.Errorf("11th sprite drawn with shade %d, want it dropped", got)
+			}
+
+			// A line with no sprites on it
+			runToLine(ppu, 9, 0)
+			if n := countShade(ppu, 8, 3); n != 0 {
+				t.Errorf("line 8: %d sprite pixels, want 0", n)
+			}
+		})
+	}
+}
+
+func TestSpritePriority(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		x0, x1  byte
+		overlap int // Screen X of a pixel both sprites cover
+		want    byte
+	}{
+		// Smaller X wins, even though it's later in OAM
+		{"smaller X", 24, 20, 16, 1},
+		{"larger X", 20, 24, 16, 3},
+		// Same X, the first in OAM wins
+		{"same X", 20, 20, 12, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gb, ppu := newSpriteTest(t)
+			setSprite(gb, 0, tc.x0, 0x00)
+			setSprite(gb, 1, tc.x1, 0x10)
+
+			runToLine(ppu, 1, 0)
+			if got := ppu.backBuffer[tc.overlap]; got != tc.want {
+				t.Errorf("overlapping pixel shade %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,574 @@
+package gameboy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Save states are a magic string and version, followed by a list of chunks. Each chunk
+// is a 4 character ID, a 32-bit little endian length and then the data. Unknown chunks
+// are skipped, and chunks shorter than expected have their missing fields zeroed, so
+// new fields & chunks can be added without breaking old states. The version is bumped
+// whenever that happens, or when the meaning of a chunk changes
+const STATE_VERSION = 2
+
+// States older than this were saved with chunks that can't be converted, like the timer
+// before it was rebuilt around the system counter, so they're rejected
+const OLDEST_STATE_VERSION = 2
+
+// Chunks every state has had since the oldest supported version, a state without them is
+// rejected. Chunks added in later versions will start zeroed when loading an older state
+var requiredChunks = []string{"ROM ", "CPU ", "PPU ", "TIMC", "DMA ", "JOYP", "APU ", "VRAM", "WRAM", "OAM ", "IO  ", "HRAM", "IE  "}
+
+var stateMagic = []byte("DMGOSTATE")
+
+var ErrBadState = errors.New("not a valid save state")
+
+// Cartridges keep their bank registers in a save state, RAM is saved using the battery interface
+type cartState interface {
+	saveRegs() []byte
+	loadRegs(data []byte) error
+}
+
+type stateChunk struct {
+	id   string
+	data []byte
+}
+
+type cpuState struct {
+	AF, BC, DE, HL, SP, PC uint16
+	IME, Halted            bool
+
+	EnableIME, HaltBug, Stopped bool
+}
+
+type ppuState struct {
+	Scanline   byte
+	DotCounter int32
+	Mode       byte
+	StatLine   bool
+
+	WindowLine      int32
+	WindowTriggered bool
+
+	LCDOn, FirstLine, SkipFrame bool
+}
+
+type timerState struct {
+	Counter        uint16
+	TIMA, TMA, TAC byte
+	OverflowDelay  int32
+	Reloading      int32
+}
+
+type dmaState struct {
+	Source, Next  uint16
+	Copied, Delay int32
+	Active        bool
+}
+
+type apuState struct {
+	Regs      [0x20]byte
+	WaveRAM   [16]byte
+	Power     bool
+	FrameStep byte
+
+	Ch1, Ch2 squareState
+	Ch3      waveState
+	Ch4      noiseState
+
+	SampleCounter     int32
+	CapLeft, CapRight float64
+}
+
+type squareState struct {
+	Enabled       bool
+	Length        int32
+	LengthEnabled bool
+	Volume        byte
+	EnvTimer      int32
+	DutyStep      byte
+	Timer         int32
+
+	SweepTimer   int32
+	SweepEnabled bool
+	SweepShadow  int32
+	SweepNegUsed bool
+}
+
+type waveState struct {
+	Enabled       bool
+	Length        int32
+	LengthEnabled bool
+	Position      byte
+	Timer         int32
+}
+
+type noiseState struct {
+	Enabled       bool
+	Length        int32
+	LengthEnabled bool
+	Volume        byte
+	EnvTimer      int32
+	LFSR          uint16
+	Timer         int32
+}
+
+type joypadState struct {
+	A, B, Select, Start, Right, Left, Up, Down bool
+}
+
+// Identifies the cartridge a state was saved with
+type romState struct {
+	Title          [16]byte
+	GlobalChecksum uint16
+}
+
+// SaveState writes the state of the whole machine, it can be restored with LoadState
+func (gb *Gameboy) SaveState(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(stateMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, uint32(STATE_VERSION)); err != nil {
+		return err
+	}
+
+	cpu := gb.cpu
+	chunks := []stateChunk{
+		{"ROM ", encodeState(gb.romState())},
+		{"CPU ", encodeState(cpuState{cpu.af, cpu.bc, cpu.de, cpu.hl, cpu.sp, cpu.pc, cpu.ime, cpu.halted, cpu.enableIME, cpu.haltBug, cpu.stopped})},
+		{"PPU ", encodeState(gb.ppu.state())},
+		{"TIMC", encodeState(gb.timer.state())},
+		{"DMA ", encodeState(gb.mapper.dma.state())},
+		{"JOYP", encodeState(gb.Buttons.state())},
+		{"APU ", encodeState(gb.apu.state())},
+		{"VRAM", gb.mapper.vram},
+		{"WRAM", gb.mapper.wram},
+		{"OAM ", gb.mapper.oam},
+		{"IO  ", gb.mapper.io},
+		{"HRAM", gb.mapper.hram},
+		{"IE  ", []byte{gb.mapper.interrupt}},
+	}
+
+	if cart, ok := gb.mapper.cart.(cartState); ok {
+		chunks = append(chunks, stateChunk{"CREG", cart.saveRegs()})
+	}
+	if cart, ok := gb.mapper.cart.(battery); ok {
+		chunks = append(chunks, stateChunk{"CRAM", cart.saveRAM()})
+	}
+
+	for _, c := range chunks {
+		if _, err := bw.WriteString(c.id); err != nil {
+			return err
+		}
+		if err := binary.Write(bw, binary.LittleEndian, uint32(len(c.data))); err != nil {
+			return err
+		}
+		if _, err := bw.Write(c.data); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// LoadState restores the machine from a state written by SaveState, the same ROM must be loaded
+func (gb *Gameboy) LoadState(r io.Reader) error {
+	chunks, err := readStateChunks(r)
+	if err != nil {
+		return err
+	}
+
+	// Check everything before changing anything, so a bad state leaves the machine untouched
+	for _, id := range requiredChunks {
+		if chunks[id] == nil {
+			return fmt.Errorf("%w: missing chunk %q", ErrBadState, id)
+		}
+	}
+
+	// Cartridges have always saved their registers & RAM, when they have them
+	if _, ok := gb.mapper.cart.(cartState); ok && chunks["CREG"] == nil {
+		return fmt.Errorf("%w: missing chunk %q", ErrBadState, "CREG")
+	}
+	if _, ok := gb.mapper.cart.(battery); ok && chunks["CRAM"] == nil {
+		return fmt.Errorf("%w: missing chunk %q", ErrBadState, "CRAM")
+	}
+
+	var rom romState
+	if err := decodeState(chunks["ROM "], &rom); err != nil {
+		return err
+	}
+	if rom != gb.romState() {
+		return fmt.Errorf("%w: saved with a different ROM", ErrBadState)
+	}
+
+	var cpu cpuState
+	var ppu ppuState
+	var timer timerState
+	var joypad joypadState
+	var apu apuState
+	var dma dmaState
+	for _, c := range []struct {
+		id string
+		v  any
+	}{{"CPU ", &cpu}, {"PPU ", &ppu}, {"TIMC", &timer}, {"JOYP", &joypad}, {"APU ", &apu}, {"DMA ", &dma}} {
+		if err := decodeState(chunks[c.id], c.v); err != nil {
+			return err
+		}
+	}
+
+	gb.cpu.af, gb.cpu.bc, gb.cpu.de, gb.cpu.hl = cpu.AF, cpu.BC, cpu.DE, cpu.HL
+	gb.cpu.sp, gb.cpu.pc = cpu.SP, cpu.PC
+	gb.cpu.ime, gb.cpu.halted = cpu.IME, cpu.Halted
+	gb.cpu.enableIME, gb.cpu.haltBug, gb.cpu.stopped = cpu.EnableIME, cpu.HaltBug, cpu.Stopped
+
+	gb.ppu.setState(ppu)
+	gb.timer.setState(timer)
+
+	gb.Buttons.setState(joypad)
+	gb.apu.setState(apu)
+	gb.mapper.dma.setState(dma)
+
+	copy(gb.mapper.vram, chunks["VRAM"])
+	copy(gb.mapper.wram, chunks["WRAM"])
+	copy(gb.mapper.oam, chunks["OAM "])
+	copy(gb.mapper.io, chunks["IO  "])
+	copy(gb.mapper.hram, chunks["HRAM"])
+	if len(chunks["IE  "]) > 0 {
+		gb.mapper.interrupt = chunks["IE  "][0]
+	}
+
+	if cart, ok := gb.mapper.cart.(cartState); ok {
+		if err := cart.loadRegs(chunks["CREG"]); err != nil {
+			return err
+		}
+	}
+	if cart, ok := gb.mapper.cart.(battery); ok {
+		cart.loadRAM(chunks["CRAM"])
+	}
+
+	return nil
+}
+
+func (gb *Gameboy) romState() romState {
+	s := romState{}
+	if gb.header != nil {
+		copy(s.Title[:], gb.header.Title)
+		s.GlobalChecksum = gb.header.GlobalChecksum
+	}
+
+	return s
+}
+
+// Read the header and all the chunks of a save state into a map keyed by chunk ID
+func readStateChunks(r io.Reader) (map[string][]byte, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(stateMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, stateMagic) {
+		return nil, ErrBadState
+	}
+
+	var version uint32
+	if err := binary.Read(br, binary.LittleEndian, &version); err != nil {
+		return nil, ErrBadState
+	}
+	if version > STATE_VERSION {
+		return nil, fmt.Errorf("%w: version %d is newer than supported version %d", ErrBadState, version, STATE_VERSION)
+	}
+	if version < OLDEST_STATE_VERSION {
+		return nil, fmt.Errorf("%w: version %d is older than the oldest supported version %d", ErrBadState, version, OLDEST_STATE_VERSION)
+	}
+
+	chunks := map[string][]byte{}
+	for {
+		id := make([]byte, 4)
+		if _, err := io.ReadFull(br, id); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadState, err)
+		}
+
+		var size uint32
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadState, err)
+		}
+
+		data, err := io.ReadAll(io.LimitReader(br, int64(size)))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != int(size) {
+			return nil, fmt.Errorf("%w: chunk %s is truncated", ErrBadState, id)
+		}
+
+		chunks[string(id)] = data
+	}
+
+	return chunks, nil
+}
+
+// Encode a struct of fixed size fields
+func encodeState(v any) []byte {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+		// Only possible with a programming error, a struct with variable size fields
+		panic(err)
+	}
+
+	return buf.Bytes()
+}
+
+// Decode a struct of fixed size fields, fields missing from the end of the data are left as zero
+func decodeState(data []byte, v any) error {
+	size := binary.Size(v)
+	if len(data) < size {
+		data = append(data, make([]byte, size-len(data))...)
+	}
+
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, v)
+}
+
+func (ppu *PPU) state() ppuState {
+	return ppuState{
+		Scanline:        ppu.scanline,
+		DotCounter:      int32(ppu.dotCounter),
+		Mode:            ppu.mode,
+		StatLine:        ppu.statLine,
+		WindowLine:      int32(ppu.windowLine),
+		WindowTriggered: ppu.windowTriggered,
+		LCDOn:           ppu.lcdOn,
+		FirstLine:       ppu.firstLine,
+		SkipFrame:       ppu.skipFrame,
+	}
+}
+
+func (ppu *PPU) setState(s ppuState) {
+	ppu.scanline = s.Scanline
+	ppu.dotCounter = int(s.DotCounter)
+	ppu.mode = s.Mode
+	ppu.statLine = s.StatLine
+	ppu.windowLine = int(s.WindowLine)
+	ppu.windowTriggered = s.WindowTriggered
+	ppu.lcdOn = s.LCDOn
+	ppu.firstLine = s.FirstLine
+	ppu.skipFrame = s.SkipFrame
+}
+
+func (apu *APU) state() apuState {
+	return apuState{
+		Regs:      apu.regs,
+		WaveRAM:   apu.waveRAM,
+		Power:     apu.power,
+		FrameStep: apu.frameStep,
+		Ch1:       apu.ch1.state(),
+		Ch2:       apu.ch2.state(),
+		Ch3: waveState{
+			Enabled:       apu.ch3.enabled,
+			Length:        int32(apu.ch3.length.counter),
+			LengthEnabled: apu.ch3.length.enabled,
+			Position:      apu.ch3.position,
+			Timer:         int32(apu.ch3.timer),
+		},
+		Ch4: noiseState{
+			Enabled:       apu.ch4.enabled,
+			Length:        int32(apu.ch4.length.counter),
+			LengthEnabled: apu.ch4.length.enabled,
+			Volume:        apu.ch4.env.volume,
+			EnvTimer:      int32(apu.ch4.env.timer),
+			LFSR:          apu.ch4.lfsr,
+			Timer:         int32(apu.ch4.timer),
+		},
+		SampleCounter: int32(apu.sampleCounter),
+		CapLeft:       apu.capLeft,
+		CapRight:      apu.capRight,
+	}
+}
+
+// Channels keep slices into the registers, so they're copied in place rather than replaced
+func (apu *APU) setState(s apuState) {
+	apu.regs = s.Regs
+	apu.waveRAM = s.WaveRAM
+	apu.power = s.Power
+	apu.frameStep = s.FrameStep
+	apu.ch1.setState(s.Ch1)
+	apu.ch2.setState(s.Ch2)
+
+	apu.ch3.enabled = s.Ch3.Enabled
+	apu.ch3.length.counter = int(s.Ch3.Length)
+	apu.ch3.length.enabled = s.Ch3.LengthEnabled
+	apu.ch3.position = s.Ch3.Position
+	apu.ch3.timer = int(s.Ch3.Timer)
+
+	apu.ch4.enabled = s.Ch4.Enabled
+	apu.ch4.length.counter = int(s.Ch4.Length)
+	apu.ch4.length.enabled = s.Ch4.LengthEnabled
+	apu.ch4.env.volume = s.Ch4.Volume
+	apu.ch4.env.timer = int(s.Ch4.EnvTimer)
+	apu.ch4.lfsr = s.Ch4.LFSR
+	apu.ch4.timer = int(s.Ch4.Timer)
+
+	apu.sampleCounter = int(s.SampleCounter)
+	apu.capLeft = s.CapLeft
+	apu.capRight = s.CapRight
+	apu.samples = apu.samples[:0]
+}
+
+func (ch *squareChannel) state() squareState {
+	return squareState{
+		Enabled:       ch.enabled,
+		Length:        int32(ch.length.counter),
+		LengthEnabled: ch.length.enabled,
+		Volume:        ch.env.volume,
+		EnvTimer:      int32(ch.env.timer),
+		DutyStep:      ch.dutyStep,
+		Timer:         int32(ch.timer),
+		SweepTimer:    int32(ch.sweepTimer),
+		SweepEnabled:  ch.sweepEnabled,
+		SweepShadow:   int32(ch.sweepShadow),
+		SweepNegUsed:  ch.sweepNegUsed,
+	}
+}
+
+func (ch *squareChannel) setState(s squareState) {
+	ch.enabled = s.Enabled
+	ch.length.counter = int(s.Length)
+	ch.length.enabled = s.LengthEnabled
+	ch.env.volume = s.Volume
+	ch.env.timer = int(s.EnvTimer)
+	ch.dutyStep = s.DutyStep
+	ch.timer = int(s.Timer)
+	ch.sweepTimer = int(s.SweepTimer)
+	ch.sweepEnabled = s.SweepEnabled
+	ch.sweepShadow = int(s.SweepShadow)
+	ch.sweepNegUsed = s.SweepNegUsed
+}
+
+func (t *Timer) state() timerState {
+	return timerState{
+		Counter:       t.counter,
+		TIMA:          t.tima,
+		TMA:           t.tma,
+		TAC:           t.tac,
+		OverflowDelay: int32(t.overflowDelay),
+		Reloading:     int32(t.reloading),
+	}
+}
+
+func (t *Timer) setState(s timerState) {
+	t.counter = s.Counter
+	t.tima, t.tma, t.tac = s.TIMA, s.TMA, s.TAC
+	t.overflowDelay = int(s.OverflowDelay)
+	t.reloading = int(s.Reloading)
+}
+
+func (d *dmaTransfer) state() dmaState {
+	return dmaState{
+		Source: d.source,
+		Next:   d.next,
+		Copied: int32(d.copied),
+		Delay:  int32(d.delay),
+		Active: d.active,
+	}
+}
+
+func (d *dmaTransfer) setState(s dmaState) {
+	d.source, d.next = s.Source, s.Next
+	d.copied = int(s.Copied)
+	d.delay = int(s.Delay)
+	d.active = s.Active
+}
+
+func (b *Buttons) state() joypadState {
+	return joypadState{b.butA, b.butB, b.sel, b.start, b.right, b.left, b.up, b.down}
+}
+
+func (b *Buttons) setState(s joypadState) {
+	b.butA, b.butB, b.sel, b.start = s.A, s.B, s.Select, s.Start
+	b.right, b.left, b.up, b.down = s.Right, s.Left, s.Up, s.Down
+}
+
+// Bank registers for each type of MBC
+
+type mbc1State struct {
+	RAMEnabled           bool
+	ROMBank, Bank2, Mode byte
+}
+
+func (c *mbc1) saveRegs() []byte {
+	return encodeState(mbc1State{c.ramEnabled, c.romBank, c.bank2, c.mode})
+}
+
+func (c *mbc1) loadRegs(data []byte) error {
+	s := mbc1State{}
+	if err := decodeState(data, &s); err != nil {
+		return err
+	}
+
+	c.ramEnabled, c.romBank, c.bank2, c.mode = s.RAMEnabled, s.ROMBank, s.Bank2, s.Mode
+	return nil
+}
+
+type mbc2State struct {
+	RAMEnabled bool
+	ROMBank    byte
+}
+
+func (c *mbc2) saveRegs() []byte {
+	return encodeState(mbc2State{c.ramEnabled, c.romBank})
+}
+
+func (c *mbc2) loadRegs(data []byte) error {
+	s := mbc2State{}
+	if err := decodeState(data, &s); err != nil {
+		return err
+	}
+
+	c.ramEnabled, c.romBank = s.RAMEnabled, s.ROMBank
+	return nil
+}
+
+type mbc3State struct {
+	RAMEnabled                bool
+	ROMBank, Bank, LatchWrite byte
+}
+
+func (c *mbc3) saveRegs() []byte {
+	return encodeState(mbc3State{c.ramEnabled, c.romBank, c.bank, c.latchWrite})
+}
+
+func (c *mbc3) loadRegs(data []byte) error {
+	s := mbc3State{}
+	if err := decodeState(data, &s); err != nil {
+		return err
+	}
+
+	c.ramEnabled, c.romBank, c.bank, c.latchWrite = s.RAMEnabled, s.ROMBank, s.Bank, s.LatchWrite
+	return nil
+}
+
+type mbc5State struct {
+	RAMEnabled bool
+	ROMBank    uint16
+	RAMBank    byte
+	RumbleOn   bool
+}
+
+func (c *mbc5) saveRegs() []byte {
+	return encodeState(mbc5State{c.ramEnabled, c.romBank, c.ramBank, c.rumbleOn})
+}
+
+func (c *mbc5) loadRegs(data []byte) error {
+	s := mbc5State{}
+	if err := decodeState(data, &s); err != nil {
+		return err
+	}
+
+	c.ramEnabled, c.romBank, c.ramBank, c.rumbleOn = s.RAMEnabled, s.ROMBank, s.RAMBank, s.RumbleOn
+	return nil
+}
@@ -0,0 +1,70 @@
+package gameboy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// Copy of a save state with one of its chunks taken out
+func withoutChunk(state []byte, id string) []byte {
+	header := len(stateMagic) + 4
+	out := append([]byte{}, state[:header]...)
+
+	for rest := state[header:]; len(rest) > 0; {
+		size := int(binary.LittleEndian.Uint32(rest[4:8]))
+		if string(rest[:4]) != id {
+			out = append(out, rest[:8+size]...)
+		}
+		rest = rest[8+size:]
+	}
+
+	return out
+}
+
+func TestLoadStateMissingChunk(t *testing.T) {
+	gb := newTestGameboy(t)
+
+	var buf bytes.Buffer
+	if err := gb.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	pc := gb.cpu.pc
+	gb.cpu.pc = 0x1234
+
+	for _, id := range append(requiredChunks, "CREG") {
+		err := gb.LoadState(bytes.NewReader(withoutChunk(buf.Bytes(), id)))
+		if !errors.Is(err, ErrBadState) {
+			t.Errorf("without %q: got error %v, want ErrBadState", id, err)
+		}
+		if gb.cpu.pc != 0x1234 {
+			t.Fatalf("without %q: PC changed to 0x%04X", id, gb.cpu.pc)
+		}
+	}
+
+	if err := gb.LoadState(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if gb.cpu.pc != pc {
+		t.Errorf("PC 0x%04X after loading, want 0x%04X", gb.cpu.pc, pc)
+	}
+}
+
+func TestLoadStateVersion(t *testing.T) {
+	gb := newTestGameboy(t)
+
+	var buf bytes.Buffer
+	if err := gb.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []uint32{OLDEST_STATE_VERSION - 1, STATE_VERSION + 1} {
+		state := append([]byte{}, buf.Bytes()...)
+		binary.LittleEndian.PutUint32(state[len(stateMagic):], version)
+
+		if err := gb.LoadState(bytes.NewReader(state)); !errors.Is(err, ErrBadState) {
+			t.Errorf("version %d: got error %v, want ErrBadState", version, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package gameboy
+
+// The DIV register after the boot ROM has run, with the lower 8 bits of the counter
+const DIV_AFTER_BOOT = 0xABCC
+
+// Bit of the system counter for each TAC clock select, 4096Hz, 262144Hz, 65536Hz & 16384Hz
+var timerBits = [4]uint{9, 3, 5, 7}
+
+// Bit of the system counter which clocks the APU frame sequencer, bit 4 of DIV
+const FRAME_SEQUENCER_BIT = 12
+
+// Cycles TIMA reads as zero after it overflows, before it's reloaded from TMA
+const TIMER_RELOAD_DELAY = 4
+
+// Timer is built around a 16-bit system counter incremented every cycle, DIV is the upper
+// 8 bits of it. TIMA is incremented on the falling edge of the bit of the counter picked
+// by TAC, ANDed with the enable bit, which is why writes to DIV & TAC can also clock it
+// https://gbdev.io/pandocs/Timer_Obscure_Behaviour.html
+type Timer struct {
+	counter uint16
+	tima    byte
+	tma     byte
+	tac     byte
+
+	// Cycles left until TIMA is reloaded after overflowing, zero when not overflowed
+	overflowDelay int
+
+	// Cycles left of the cycle where TIMA was reloaded, writes to TIMA are ignored then
+	reloading int
+
+	apu *APU
+	gb  *Gameboy
+}
+
+func NewTimer(apu *APU) *Timer {
+	return &Timer{
+		apu: apu,
+	}
+}
+
+// Advance the timer by the given number of cycles, one at a time so no edges are missed
+func (t *Timer) cycle(cycles int) {
+	for i := 0; i < cycles; i++ {
+		if t.reloading > 0 {
+			t.reloading--
+		}
+
+		if t.overflowDelay > 0 {
+			t.overflowDelay--
+			if t.overflowDelay == 0 {
+				t.tima = t.tma
+				t.reloading = TIMER_RELOAD_DELAY
+				t.gb.requestInterrupt(INT_TIMER)
+			}
+		}
+
+		t.setCounter(t.counter + 1)
+	}
+}
+
+// Change the system counter, clocking TIMA and the frame sequencer on falling edges
+func (t *Timer) setCounter(counter uint16) {
+	oldSignal := t.signal()
+	oldFrameBit := checkBit16(t.counter, FRAME_SEQUENCER_BIT)
+
+	t.counter = counter
+
+	if oldSignal && !t.signal() {
+		t.increment()
+	}
+	if oldFrameBit && !checkBit16(t.counter, FRAME_SEQUENCER_BIT) {
+		t.apu.clockFrameSequencer()
+	}
+}
+
+// Input to the falling edge detector, the selected counter bit AND the enable bit
+func (t *Timer) signal() bool {
+	return checkBit(t.tac, 2) && checkBit16(t.counter, timerBits[t.tac&0x03])
+}
+
+func (t *Timer) increment() {
+	t.tima++
+
+	// On overflow TIMA reads zero for a cycle, before being reloaded & the interrupt fired
+	if t.tima == 0 {
+		t.overflowDelay = TIMER_RELOAD_DELAY
+	}
+}
+
+func (t *Timer) read(addr uint16) byte {
+	switch addr {
+	case DIV:
+		return byte(t.counter >> 8)
+	case TIMA:
+		return t.tima
+	case TMA:
+		return t.tma
+	case TAC:
+		// Only the lower 3 bits are used, the rest read as 1
+		return t.tac | 0xF8
+	}
+
+	return 0xFF
+}
+
+func (t *Timer) write(addr uint16, data byte) {
+	switch addr {
+	case DIV:
+		// Any write resets the whole counter, which can cause a falling edge
+		t.setCounter(0)
+
+	case TIMA:
+		// Writing in the cycle TIMA was reloaded is ignored
+		if t.reloading > 0 {
+			return
+		}
+
+		// Writing while waiting to reload cancels the reload and the interrupt
+		t.overflowDelay = 0
+		t.tima = data
+
+	case TMA:
+		t.tma = data
+
+		// Writing in the cycle TIMA was reloaded also changes TIMA
+		if t.reloading > 0 {
+			t.tima = data
+		}
+
+	case TAC:
+		// Disabling the timer or changing the clock can cause a falling edge
+		oldSignal := t.signal()
+		t.tac = data & 0x07
+		if oldSignal && !t.signal() {
+			t.increment()
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package gameboy
+
+import "testing"
+
+// Gameboy with the timer counter cleared and no interrupts requested
+func newTestTimer(t *testing.T) (*Gameboy, *Timer) {
+	t.Helper()
+
+	gb := newTestGameboy(t)
+	gb.timer.counter = 0
+	gb.mapper.write(IF, 0)
+
+	return gb, gb.timer
+}
+
+func TestTimerTACFallingEdge(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cycles int
+		tac    byte
+		want   byte
+	}{
+		// After 8 cycles bit 3 of the counter is set, so the signal goes from high to low
+		{"disabled", 8, 0x01, 1},
+		{"bit 9 selected", 8, 0x04, 1},
+		{"still bit 3", 8, 0x05, 0},
+		// After 4 cycles the signal was already low
+		{"disabled while low", 4, 0x01, 0},
+		{"bit 9 selected while low", 4, 0x04, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, timer := newTestTimer(t)
+			timer.write(TAC, 0x05)
+			timer.cycle(tc.cycles)
+
+			timer.write(TAC, tc.tac)
+			if got := timer.read(TIMA); got != tc.want {
+				t.Errorf("TIMA %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTimerDIVWriteFallingEdge(t *testing.T) {
+	_, timer := newTestTimer(t)
+	timer.write(TAC, 0x05)
+
+	timer.cycle(8)
+	timer.write(DIV, 0)
+	if got := timer.read(TIMA); got != 1 {
+		t.Errorf("TIMA %d after resetting DIV with bit 3 set, want 1", got)
+	}
+
+	timer.cycle(4)
+	timer.write(DIV, 0)
+	if got := timer.read(TIMA); got != 1 {
+		t.Errorf("TIMA %d after resetting DIV with bit 3 clear, want 1", got)
+	}
+}
+
+func TestTimerOverflowReload(t *testing.T) {
+	gb, timer := newTestTimer(t)
+	timer.write(TMA, 0x80)
+	timer.write(TIMA, 0xFF)
+	timer.write(TAC, 0x05)
+
+	// TIMA goes up every 16 cycles, on the falling edge of bit 3
+	timer.cycle(16)
+	for i := 0; i < TIMER_RELOAD_DELAY; i++ {
+		if got := timer.read(TIMA); got != 0 {
+			t.Fatalf("TIMA 0x%02X %d cycles after overflowing, want 0", got, i)
+		}
+		if gb.mapper.read(IF)&INT_TIMER != 0 {
+			t.Fatalf("timer interrupt %d cycles after overflowing, want it delayed", i)
+		}
+		timer.cycle(1)
+	}
+
+	if got := timer.read(TIMA); got != 0x80 {
+		t.Errorf("TIMA 0x%02X after the delay, want TMA 0x80", got)
+	}
+	if gb.mapper.read(IF)&INT_TIMER == 0 {
+		t.Error("no timer interrupt after the delay")
+	}
+}
+
+func TestTimerOverflowCancelledByWrite(t *testing.T) {
+	gb, timer := newTestTimer(t)
+	timer.write(TMA, 0x80)
+	timer.write(TIMA, 0xFF)
+	timer.write(TAC, 0x05)
+
+	timer.cycle(16)
+	timer.write(TIMA, 0x10)
+	timer.cycle(TIMER_RELOAD_DELAY)
+
+	if got := timer.read(TIMA); got != 0x10 {
+		t.Errorf("TIMA 0x%02X, want the written 0x10", got)
+	}
+	if gb.mapper.read(IF)&INT_TIMER != 0 {
+		t.Error("timer interrupt requested after the reload was cancelled")
+	}
+}
@@ -48,3 +48,7 @@ func twoBitValue(b byte, bit uint) int {
 func checkBit(b byte, bit uint) bool {
 	return b&(1<<bit) != 0
 }
+
+func checkBit16(v uint16, bit uint) bool {
+	return v&(1<<bit) != 0
+}
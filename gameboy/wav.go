@@ -0,0 +1,83 @@
+package gameboy
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Size of the RIFF & format headers before the sample data
+const WAV_HEADER_SIZE = 44
+
+// WAVWriter writes the sound from ReadAudio to a 16-bit stereo PCM .wav file
+// The sizes in the header aren't known until the end, so they're filled in by Close
+type WAVWriter struct {
+	w    io.WriteSeeker
+	size uint32 // Bytes of sample data written so far
+}
+
+type wavHeader struct {
+	RIFF          [4]byte
+	RIFFSize      uint32
+	WAVE          [4]byte
+	Fmt           [4]byte
+	FmtSize       uint32
+	Format        uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+	Data          [4]byte
+	DataSize      uint32
+}
+
+// NewWAVWriter writes the header for an empty .wav file, samples are then added with Write
+func NewWAVWriter(w io.WriteSeeker) (*WAVWriter, error) {
+	ww := &WAVWriter{w: w}
+	if err := ww.writeHeader(); err != nil {
+		return nil, err
+	}
+
+	return ww, nil
+}
+
+// Write adds interleaved left & right samples to the file
+func (ww *WAVWriter) Write(samples []int16) error {
+	if err := binary.Write(ww.w, binary.LittleEndian, samples); err != nil {
+		return err
+	}
+
+	ww.size += uint32(len(samples) * 2)
+	return nil
+}
+
+// Close fills in the sizes in the header, it doesn't close the underlying writer
+func (ww *WAVWriter) Close() error {
+	if _, err := ww.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := ww.writeHeader(); err != nil {
+		return err
+	}
+
+	_, err := ww.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+func (ww *WAVWriter) writeHeader() error {
+	return binary.Write(ww.w, binary.LittleEndian, wavHeader{
+		RIFF:          [4]byte{'R', 'I', 'F', 'F'},
+		RIFFSize:      WAV_HEADER_SIZE - 8 + ww.size,
+		WAVE:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		Format:        1, // PCM
+		Channels:      2,
+		SampleRate:    SAMPLE_RATE,
+		ByteRate:      SAMPLE_RATE * 4,
+		BlockAlign:    4,
+		BitsPerSample: 16,
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      ww.size,
+	})
+}
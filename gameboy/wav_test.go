@@ -0,0 +1,59 @@
+package gameboy
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWAVHeaderSizes(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "test.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	ww, err := NewWAVWriter(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := ww.Write(make([]int16, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ww.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// More samples written after closing go on the end of the file
+	if err := ww.Write([]int16{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ww.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataSize := 302 * 2
+	if len(data) != WAV_HEADER_SIZE+dataSize {
+		t.Fatalf("file is %d bytes, want %d", len(data), WAV_HEADER_SIZE+dataSize)
+	}
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" || string(data[36:40]) != "data" {
+		t.Errorf("bad chunk IDs %q %q %q", data[0:4], data[8:12], data[36:40])
+	}
+	if got := binary.LittleEndian.Uint32(data[4:]); got != uint32(len(data)-8) {
+		t.Errorf("RIFF size %d, want %d", got, len(data)-8)
+	}
+	if got := binary.LittleEndian.Uint32(data[40:]); got != uint32(dataSize) {
+		t.Errorf("data size %d, want %d", got, dataSize)
+	}
+	if got := binary.LittleEndian.Uint32(data[24:]); got != SAMPLE_RATE {
+		t.Errorf("sample rate %d, want %d", got, SAMPLE_RATE)
+	}
+}
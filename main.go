@@ -2,13 +2,17 @@ package main
 
 import (
 	"dmgo/gameboy"
+	"flag"
+	"fmt"
 	"image"
 	"image/color"
 	"image/png"
 	"log"
 	"os"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/audio"
 	"github.com/hajimehoshi/ebiten/v2/inpututil"
 	"github.com/hajimehoshi/ebiten/v2/text/v2"
 	"gopkg.in/yaml.v2"
@@ -17,15 +21,39 @@ import (
 var (
 	gb         *gameboy.Gameboy
 	faceSource *text.GoTextFaceSource
-	config     gameboy.Config
-)
+	config     frontendConfig
+	rumbling   bool
+	romPath    string
+
+	sound    = &audioStream{}
+	player   *audio.Player
+	muted    bool
+	recorder *recording
 
-const (
-	clockSpeed = 4194304
-	scale      = 4
+	// Side panel shows the sound channel scopes instead of the debug info
+	showScopes bool
 )
 
-type Game struct{}
+const scale = 4
+
+// Settings in config.yaml, the emulator's own and the ones only the frontend uses
+type frontendConfig struct {
+	gameboy.Config `yaml:",inline"`
+
+	// Sound volume from 0.0 to 1.0
+	Volume float64 `yaml:"volume"`
+}
+
+// Keys for the save state slots
+var stateKeys = []ebiten.Key{
+	ebiten.KeyF1, ebiten.KeyF2, ebiten.KeyF3, ebiten.KeyF4,
+	ebiten.KeyF5, ebiten.KeyF6, ebiten.KeyF7, ebiten.KeyF8,
+}
+
+type Game struct {
+	// The emulator screen is copied here each frame to draw it
+	screen *ebiten.Image
+}
 
 func init() {
 	// Load font
@@ -121,23 +149,72 @@ func (g *Game) Update() error {
 		gb.Running = !gb.Running
 	}
 
-	// Main emulator loop
-	gb.Update(clockSpeed / tps)
+	// Save states, shift+F1-F8 saves and F1-F8 loads
+	for i, key := range stateKeys {
+		if inpututil.IsKeyJustPressed(key) {
+			if ebiten.IsKeyPressed(ebiten.KeyShift) {
+				saveState(i + 1)
+			} else {
+				loadState(i + 1)
+			}
+		}
+	}
+
+	// Mute/unmute
+	if inpututil.IsKeyJustPressed(ebiten.KeyM) {
+		muted = !muted
+		setVolume()
+	}
+
+	// Switch the side panel between debug info and the sound channels
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+		showScopes = !showScopes
+	}
+
+	updateChannelKeys()
+
+	// Start/stop recording the sound
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		toggleRecording()
+	}
+
+	// Main emulator loop, the speed is adjusted slightly to keep pace with the audio
+	cycles := float64(gameboy.CLOCK_SPEED/tps) * sound.rateAdjust()
+	gb.Update(int(cycles))
+
+	samples := readAudio(gb)
+	sound.fill(samples)
+	if recorder != nil {
+		recorder.write(samples)
+	}
 
 	return nil
 }
 
 func (g *Game) Draw(screen *ebiten.Image) {
-	gb.Render()
-
 	// Render emulator screen
 	op := &ebiten.DrawImageOptions{}
 	op.GeoM.Scale(float64(scale), float64(scale))
 	op.Filter = ebiten.FilterNearest
-	screen.DrawImage(gb.GetScreen(), op)
+	g.screen.WritePixels(gb.GetScreen().Pix)
+	screen.DrawImage(g.screen, op)
+
+	if showScopes {
+		drawScopes(screen, float32(163*scale), 10)
+		return
+	}
 
 	// Debug info
 	msg := gb.GetDebugInfo()
+	if rumbling {
+		msg += "\n*** RUMBLE ***\n"
+	}
+	if muted {
+		msg += "\n*** MUTED ***\n"
+	}
+	if recorder != nil {
+		msg += "\n*** RECORDING ***\n"
+	}
 	textOp := &text.DrawOptions{}
 	textOp.GeoM.Translate(float64(163*scale), 20)
 	textOp.LineSpacing = 22
@@ -155,6 +232,10 @@ func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeigh
 
 // Entry point is here
 func main() {
+	recordPath := flag.String("record-audio", "", "Record the sound to this .wav file")
+	frames := flag.Int("frames", 0, "Run this many frames headless, without opening a window")
+	flag.Parse()
+
 	// Read config.yaml file
 	configFile, err := os.Open("./config.yaml")
 	if err != nil {
@@ -167,16 +248,43 @@ func main() {
 		log.Fatal(err)
 	}
 
-	gb = gameboy.NewGameboy(config)
-	if len(os.Args) > 1 {
-		gb.LoadROM(os.Args[1])
+	gb, err = gameboy.NewGameboy(config.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	gb.OnRumble = func(on bool) { rumbling = on }
+
+	if flag.NArg() > 0 {
+		romPath = flag.Arg(0)
+		if err := gb.LoadROM(romPath); err != nil {
+			log.Fatal(err)
+		}
 	} else {
 		log.Println("No game cart ROM specified, booting without a cart")
+		log.Println("Usage: dmgo [--record-audio out.wav] [--frames N] <rom.gb | rom.zip | rom.zip#game.gb | rom.gb.gz>")
+	}
+
+	if *recordPath != "" {
+		recorder, err = startRecording(*recordPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *frames > 0 {
+		runHeadless(*frames)
+		return
 	}
 
 	gb.Running = true
 
-	game := &Game{}
+	player = newAudioPlayer(sound)
+	setVolume()
+
+	game := &Game{
+		screen: ebiten.NewImage(gameboy.SCREEN_WIDTH, gameboy.SCREEN_HEIGHT),
+	}
 	ebiten.SetWindowSize(160*scale+140*scale, 144*scale)
 	ebiten.SetWindowTitle("Gameboy Emulator (DMGO)")
 
@@ -184,15 +292,128 @@ func main() {
 	if err := ebiten.RunGame(game); err != nil {
 		log.Fatal(err)
 	}
+
+	shutdown()
+}
+
+// Run a fixed number of frames as fast as possible, with no window or sound playing
+// With --record-audio this gives the same .wav every time, for checking sound changes
+func runHeadless(frames int) {
+	log.Printf("Running %d frames headless\n", frames)
+
+	for range frames {
+		gb.RunFrame()
+
+		if recorder != nil {
+			recorder.write(readAudio(gb))
+		}
+	}
+
+	shutdown()
 }
 
-func readConfig(file *os.File) (gameboy.Config, error) {
+// Emulation has finished, make sure the cartridge RAM and any recording aren't lost
+func shutdown() {
+	if recorder != nil {
+		recorder.stop()
+		recorder = nil
+	}
+
+	if err := gb.SaveRAM(); err != nil {
+		log.Println(err)
+	}
+}
+
+func readConfig(file *os.File) (frontendConfig, error) {
+	// Full volume unless the config file says otherwise
+	config := frontendConfig{Volume: 1.0}
+
 	// Read the file
 	decoder := yaml.NewDecoder(file)
 	err := decoder.Decode(&config)
 	if err != nil {
-		return gameboy.Config{}, err
+		return frontendConfig{}, err
 	}
 
+	// The player only takes a volume from 0 to 1
+	config.Volume = max(0, min(config.Volume, 1))
+
 	return config, nil
 }
+
+func setVolume() {
+	if player == nil {
+		return
+	}
+
+	if muted {
+		player.SetVolume(0)
+	} else {
+		player.SetVolume(config.Volume)
+	}
+}
+
+// Save states are kept next to the ROM, e.g. game.gb has slot 1 in game.ss1
+func statePath(slot int) string {
+	return fmt.Sprintf("%s.ss%d", gameboy.SaveBaseName(romPath), slot)
+}
+
+// Recordings started with the R key are named after the ROM and the time
+func toggleRecording() {
+	if recorder != nil {
+		recorder.stop()
+		recorder = nil
+		return
+	}
+
+	base := gameboy.SaveBaseName(romPath)
+	if base == "" {
+		base = "dmgo"
+	}
+
+	var err error
+	recorder, err = startRecording(fmt.Sprintf("%s-%s.wav", base, time.Now().Format("20060102-150405")))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func saveState(slot int) {
+	if romPath == "" {
+		return
+	}
+
+	file, err := os.Create(statePath(slot))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer file.Close()
+
+	if err := gb.SaveState(file); err != nil {
+		log.Println(err)
+		return
+	}
+
+	log.Printf("Saved state to slot %d\n", slot)
+}
+
+func loadState(slot int) {
+	if romPath == "" {
+		return
+	}
+
+	file, err := os.Open(statePath(slot))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer file.Close()
+
+	if err := gb.LoadState(file); err != nil {
+		log.Println(err)
+		return
+	}
+
+	log.Printf("Loaded state from slot %d\n", slot)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// Size of each channel's scope in the side panel, one sample per pixel across
+const scopeWidth = 440
+const scopeHeight = 100
+
+var channelNames = []string{"Square 1", "Square 2", "Wave", "Noise"}
+
+// Keys 1-4 mute each sound channel, and with shift solo it
+var channelKeys = []ebiten.Key{ebiten.KeyDigit1, ebiten.KeyDigit2, ebiten.KeyDigit3, ebiten.KeyDigit4}
+
+var (
+	scopeColor = color.RGBA{0x00, 0xee, 0x11, 0xff}
+	mutedColor = color.RGBA{0x55, 0x55, 0x55, 0xff}
+)
+
+func updateChannelKeys() {
+	for i, key := range channelKeys {
+		if !inpututil.IsKeyJustPressed(key) {
+			continue
+		}
+
+		channel := i + 1
+		if ebiten.IsKeyPressed(ebiten.KeyShift) {
+			soloChannel(channel)
+		} else {
+			gb.SetChannelMuted(channel, !gb.ChannelMuted(channel))
+		}
+	}
+}
+
+// Mute every channel but this one, or if it's already the only one playing unmute them all
+func soloChannel(channel int) {
+	soloed := !gb.ChannelMuted(channel)
+	for c := 1; c <= len(channelNames); c++ {
+		if c != channel && !gb.ChannelMuted(c) {
+			soloed = false
+		}
+	}
+
+	for c := 1; c <= len(channelNames); c++ {
+		gb.SetChannelMuted(c, c != channel && !soloed)
+	}
+}
+
+// Draw a scope for each sound channel, in place of the debug info
+func drawScopes(screen *ebiten.Image, x, y float32) {
+	face := &text.GoTextFace{Source: faceSource, Size: 20}
+
+	for i, name := range channelNames {
+		channel := i + 1
+		clr := scopeColor
+		if gb.ChannelMuted(channel) {
+			clr = mutedColor
+			name += " (muted)"
+		}
+
+		textOp := &text.DrawOptions{}
+		textOp.GeoM.Translate(float64(x), float64(y))
+		textOp.ColorScale.ScaleWithColor(clr)
+		text.Draw(screen, name, face, textOp)
+
+		top := y + 26
+		vector.StrokeRect(screen, x, top, scopeWidth, scopeHeight, 1, mutedColor, false)
+
+		samples := gb.GetChannelScope(channel)
+		start := scopeTrigger(samples)
+		for px := 0; px < scopeWidth-1; px++ {
+			y0 := top + scopeHeight - samples[start+px]*scopeHeight
+			y1 := top + scopeHeight - samples[start+px+1]*scopeHeight
+			vector.StrokeLine(screen, x+float32(px), y0, x+float32(px+1), y1, 2, clr, false)
+		}
+
+		y += scopeHeight + 40
+	}
+}
+
+// Find a rising edge to start the trace at, so repeating waves stay still on the scope
+func scopeTrigger(samples []float32) int {
+	low, high := samples[0], samples[0]
+	for _, s := range samples {
+		low = min(low, s)
+		high = max(high, s)
+	}
+
+	mid := (low + high) / 2
+	last := len(samples) - scopeWidth
+	for i := 0; i < last; i++ {
+		if samples[i] <= mid && samples[i+1] > mid {
+			return i
+		}
+	}
+
+	// No edge, e.g. the channel is silent, so show the most recent samples
+	return last
+}